@@ -0,0 +1,123 @@
+package surface
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	defaultRefine = 3
+	maxRefine     = 10
+)
+
+// sampleAdaptive computes and projects the cells of a quadtree over the
+// grid of opts instead of every grid cell. It starts from blocks of
+// 2^opts.Refine grid cells per side and splits each in four while the
+// surface bends away from the flat block by more than opts.Adaptive times
+// the range of its heights, or of its extent for a parametric surface, down
+// to single grid cells. Flat regions thus take few polygons and sharp
+// features many. Neighboring blocks of different sizes may leave hairline
+// cracks where they meet. The polygons of each row of blocks make up a row
+// of the mesh.
+func sampleAdaptive(ctx context.Context, opts Options) (*mesh, error) {
+	defer opts.trace("sample")()
+	sin, cos := math.Sincos(opts.Rotate * math.Pi / 180)
+	g, pr := opts.grid(), opts.projection()
+	nx, ny := opts.Cells, opts.cellsY()
+	refine := opts.Refine
+	if refine == 0 {
+		refine = defaultRefine
+	}
+	size := min(1<<min(refine, maxRefine), max(nx, ny))
+	rows := (nx + size - 1) / size
+	corner := func(i, j int) [3]float64 {
+		x, y, z := opts.Projector.Corner(g, i, j)
+		return [3]float64{x, y, opts.mapZ(z)}
+	}
+
+	// The tolerance is relative to the heights at the corners of the blocks.
+	span := 0.0
+	if p, ok := opts.Projector.(ParametricProjector); ok {
+		span = 2 * p.Extent()
+	} else {
+		ticks := func(n int) []int { // grid indices of the corners of the blocks
+			var t []int
+			for i := 0; i < n; i += size {
+				t = append(t, i)
+			}
+			return append(t, n)
+		}
+		zmin, zmax := math.Inf(1), math.Inf(-1)
+		for _, i := range ticks(nx) {
+			for _, j := range ticks(ny) {
+				if z := corner(i, j)[2]; !math.IsNaN(z) && !math.IsInf(z, 0) {
+					zmin, zmax = min(zmin, z), max(zmax, z)
+				}
+			}
+		}
+		if zmax > zmin {
+			span = zmax - zmin
+		}
+	}
+	tolerance := opts.Adaptive * span
+
+	// bend returns how far the middles of the edges and of block (i0,j0)
+	// to (i1,j1) are from the interpolation of its corners.
+	bend := func(i0, j0, i1, j1 int) float64 {
+		a, b, c, d := corner(i0, j0), corner(i1, j0), corner(i0, j1), corner(i1, j1)
+		im, jm := (i0+i1)/2, (j0+j1)/2
+		worst := 0.0
+		for _, s := range [][2]int{{im, jm}, {im, j0}, {im, j1}, {i0, jm}, {i1, jm}} {
+			su, sv := float64(s[0]-i0)/float64(i1-i0), float64(s[1]-j0)/float64(j1-j0)
+			p := corner(s[0], s[1])
+			var dist float64
+			for k := range p {
+				want := (1-su)*(1-sv)*a[k] + su*(1-sv)*b[k] + (1-su)*sv*c[k] + su*sv*d[k]
+				dist += (p[k] - want) * (p[k] - want)
+			}
+			worst = max(worst, math.Sqrt(dist))
+		}
+		if math.IsNaN(worst) {
+			return math.Inf(1) // resolve the edges of undefined regions finely
+		}
+		return worst
+	}
+
+	m := &mesh{polygons: make([][]polygon, rows)}
+	b := emptyBounds()
+	var split func(row, i0, j0, i1, j1 int)
+	split = func(row, i0, j0, i1, j1 int) {
+		if (i1-i0 > 1 || j1-j0 > 1) && bend(i0, j0, i1, j1) > tolerance {
+			is, js := []int{i0, i1}, []int{j0, j1}
+			if i1-i0 > 1 {
+				is = []int{i0, (i0 + i1) / 2, i1}
+			}
+			if j1-j0 > 1 {
+				js = []int{j0, (j0 + j1) / 2, j1}
+			}
+			for k := 1; k < len(is); k++ {
+				for l := 1; l < len(js); l++ {
+					split(row, is[k-1], js[l-1], is[k], js[l])
+				}
+			}
+			return
+		}
+		if opts.Mesh == "tri" {
+			t := cellTris(opts, g, pr, i0, j0, i1, j1, sin, cos, &b)
+			m.polygons[row] = append(m.polygons[row], t[0], t[1])
+			return
+		}
+		m.polygons[row] = append(m.polygons[row], cellRect(opts, g, pr, i0, j0, i1, j1, sin, cos, &b))
+	}
+	for row := range rows {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		i0 := row * size
+		for j0 := 0; j0 < ny; j0 += size {
+			split(row, i0, j0, min(i0+size, nx), min(j0+size, ny))
+		}
+	}
+	m.bounds = b
+	return m, nil
+}
@@ -0,0 +1,133 @@
+package surface
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+const animationFrames = 12 // views per loop of an animated SVG
+
+// animatedSVG writes an SVG that loops through animationFrames views of the
+// surface, each rotated a further 360°/animationFrames about the z axis, so
+// the output is about animationFrames times the size of a still image.
+func animatedSVG(ctx context.Context, w io.Writer, opts Options) error {
+	meshes := make([]*mesh, animationFrames)
+	b := emptyBounds()
+	for k := range meshes {
+		frame := opts
+		frame.Rotate = math.Mod(opts.Rotate+float64(k)*360/animationFrames, 360)
+		m, err := sample(ctx, frame)
+		if err != nil {
+			return err
+		}
+		meshes[k] = m
+		b.union(m.bounds)
+	}
+	defer opts.trace("encode")()
+
+	levels, err := contourLevels(b, opts)
+	if err != nil {
+		return err
+	}
+
+	svgHeader(w, b, opts)
+	for k, m := range meshes {
+		fmt.Fprintf(w, "<g visibility='hidden'>%s\n", frameVisibility(k, opts))
+		if err := surface(ctx, w, m, opts, fmt.Sprintf("f%d-", k)); err != nil {
+			return err
+		}
+		if err := contours(ctx, w, m, levels, opts); err != nil {
+			return err
+		}
+		fmt.Fprint(w, "</g>\n")
+	}
+	if opts.Legend {
+		legend(w, b, opts)
+	}
+	fmt.Fprint(w, "</svg>")
+	return nil
+}
+
+// frameVisibility returns the SMIL animation showing frame k during its
+// share of each loop.
+func frameVisibility(k int, opts Options) string {
+	values := []string{"hidden", "visible", "hidden"}
+	keyTimes := []string{"0",
+		fmt.Sprintf("%g", float64(k)/animationFrames),
+		fmt.Sprintf("%g", float64(k+1)/animationFrames)}
+	switch k {
+	case 0:
+		values, keyTimes = values[1:], keyTimes[1:]
+	case animationFrames - 1:
+		values, keyTimes = values[:2], keyTimes[:2]
+	}
+	return fmt.Sprintf("<animate attributeName='visibility' values='%s' keyTimes='%s' "+
+		"dur='%gs' calcMode='discrete' repeatCount='indefinite'/>",
+		strings.Join(values, ";"), strings.Join(keyTimes, ";"), opts.Duration.Seconds())
+}
+
+// timeSVG writes an SVG in which each cell moves through animationFrames
+// samples of tp over one loop, animating the points and fill of a single
+// polygon per cell instead of switching between whole frames. Cells that
+// are invalid in any frame are left out. Contours and tooltips are not
+// drawn as they cannot follow the moving cells.
+func timeSVG(ctx context.Context, w io.Writer, opts Options, tp TimeProjector) error {
+	meshes := make([]*mesh, animationFrames)
+	b := emptyBounds()
+	for k := range meshes {
+		frame := opts
+		frame.Projector = atTime{tp, float64(k) / animationFrames}
+		m, err := sample(ctx, frame)
+		if err != nil {
+			return err
+		}
+		meshes[k] = m
+		b.union(m.bounds)
+	}
+	defer opts.trace("encode")()
+
+	svgHeader(w, b, opts)
+	dur := opts.Duration.Seconds()
+	var points, fills []string
+	for i := 0; i < opts.Cells; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	cell:
+		for j := 0; j < opts.faces()*opts.cellsY(); j++ {
+			points, fills = points[:0], fills[:0]
+			for _, m := range meshes {
+				p := m.polygons[i][j]
+				if !p.valid {
+					continue cell
+				}
+				points = append(points, formatPoints(p.points[:2*p.sides()], opts.Precision))
+				c := shade(opts.ramp(p.value, b.vmin, b.vmax), p.shade)
+				fills = append(fills, fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+			}
+			// Return to the first frame so the loop is seamless.
+			points, fills = append(points, points[0]), append(fills, fills[0])
+			fill := "none"
+			if !opts.Wireframe {
+				fill = fills[0]
+			}
+			fmt.Fprintf(w, "<polygon points='%s' fill='%s'>", points[0], fill)
+			fmt.Fprintf(w, "<animate attributeName='points' values='%s' dur='%gs' repeatCount='indefinite'/>",
+				strings.Join(points, ";"), dur)
+			if !opts.Wireframe && slices.ContainsFunc(fills, func(f string) bool { return f != fills[0] }) {
+				fmt.Fprintf(w, "<animate attributeName='fill' values='%s' dur='%gs' repeatCount='indefinite'/>",
+					strings.Join(fills, ";"), dur)
+			}
+			fmt.Fprint(w, "</polygon>\n")
+		}
+	}
+	if opts.Legend {
+		legend(w, b, opts)
+	}
+	fmt.Fprint(w, "</svg>")
+	return nil
+}
@@ -0,0 +1,209 @@
+package surface
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// apiRequest is the body of a POST to /api/v1/render, such as
+//
+//	{
+//	  "function": "eggbox",
+//	  "domain": {"xmin": -2, "xmax": 2, "ymin": -2, "ymax": 2},
+//	  "camera": {"azimuth": 45, "elevation": 40, "projection": "perspective"},
+//	  "colors": ["0000ff@0", "ff0000@1"],
+//	  "format": "png",
+//	  "envelope": true
+//	}
+//
+// Its members are the query parameters of a render, with lists for the
+// comma-separated ones, and may be grouped in objects of any name. With
+// function=heightmap, heights holds the rows of the grid. With envelope
+// set, the response is JSON with the rendering in a data URI rather than
+// the rendering itself. In a batch, name is the name of the file of the
+// rendering.
+type apiRequest struct {
+	params   url.Values
+	heights  json.RawMessage
+	envelope bool
+	name     string
+}
+
+// apiEnvelope is the response to an apiRequest with envelope set.
+type apiEnvelope struct {
+	ContentType string `json:"contentType"`
+	ETag        string `json:"etag"`
+	Size        int    `json:"size"`
+	Data        string `json:"data"` // data URI of the rendering
+}
+
+// serveAPI answers a POST to /api/v1/render by serving the equivalent GET
+// request, or POST of the heights, and wrapping the rendering in an
+// apiEnvelope if asked to.
+func (h *Handler) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		WriteError(w, fmt.Errorf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := parseAPIRequest(http.MaxBytesReader(w, r.Body, maxHeightmapBytes))
+	if err != nil {
+		WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	r2 := req.request(r)
+	if !req.envelope {
+		h.ServeHTTP(w, r2)
+		return
+	}
+
+	buf := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	h.ServeHTTP(buf, r2)
+	if buf.status != http.StatusOK {
+		for k, v := range buf.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+		return
+	}
+	contentType := buf.header.Get("Content-Type")
+	env := apiEnvelope{
+		ContentType: contentType,
+		ETag:        buf.header.Get("ETag"),
+		Size:        buf.body.Len(),
+		Data:        "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(buf.body.Bytes()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(env)
+}
+
+// request returns the GET request for req, or POST of its heights, in
+// place of the API request r.
+func (req apiRequest) request(r *http.Request) *http.Request {
+	u := *r.URL
+	u.Path, u.RawQuery = "/", req.params.Encode()
+	r2 := r.Clone(r.Context())
+	r2.URL, r2.RequestURI = &u, u.RequestURI()
+	r2.Method, r2.Body, r2.ContentLength = http.MethodGet, http.NoBody, 0
+	r2.Header.Del("Content-Type")
+	r2.Header.Del("Accept") // negotiates the format of the envelope, if any
+	if req.heights != nil {
+		r2.Method, r2.Body = http.MethodPost, io.NopCloser(bytes.NewReader(req.heights))
+		r2.ContentLength = int64(len(req.heights))
+		r2.Header.Set("Content-Type", "application/json")
+	}
+	return r2
+}
+
+// parseAPIRequest decodes the apiRequest in r.
+func parseAPIRequest(r io.Reader) (apiRequest, error) {
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&members); err != nil {
+		return apiRequest{}, fmt.Errorf("cannot decode JSON request: %v", err)
+	}
+	req := apiRequest{params: make(url.Values)}
+	var errs ParamErrors
+	var add func(members map[string]json.RawMessage)
+	add = func(members map[string]json.RawMessage) {
+		names := make([]string, 0, len(members))
+		for name := range members {
+			names = append(names, name)
+		}
+		slices.Sort(names) // for the errors to come in a stable order
+		for _, name := range names {
+			raw := members[name]
+			switch name {
+			case "heights":
+				req.heights = raw
+				continue
+			case "envelope":
+				if err := json.Unmarshal(raw, &req.envelope); err != nil {
+					errs.add(name, fmt.Errorf("'envelope' is not a bool"))
+				}
+				continue
+			case "name":
+				if err := json.Unmarshal(raw, &req.name); err != nil {
+					errs.add(name, fmt.Errorf("'name' is not a string"))
+				}
+				continue
+			}
+			var group map[string]json.RawMessage
+			if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) && json.Unmarshal(raw, &group) == nil {
+				add(group)
+				continue
+			}
+			if !isParameter(name) {
+				errs.add(name, fmt.Errorf("unknown parameter %q", name))
+				continue
+			}
+			v, err := apiValue(raw)
+			if err != nil {
+				errs.add(name, fmt.Errorf("'%s': %v", name, err))
+				continue
+			}
+			if v != "" {
+				req.params.Set(name, v)
+			}
+		}
+	}
+	add(members)
+	if len(errs) > 0 {
+		return req, errs
+	}
+	return req, nil
+}
+
+// apiValue returns the query value of a JSON string, number, bool or list
+// of them, which is joined with commas, or "" for null.
+func apiValue(raw json.RawMessage) (string, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	scalar := func(v any) (string, error) {
+		switch v := v.(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return "", fmt.Errorf("want a string, number, bool or list of them")
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return scalar(v)
+	}
+	items := make([]string, len(list))
+	for k, item := range list {
+		s, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		items[k] = s
+	}
+	return strings.Join(items, ","), nil
+}
+
+// bufferedResponse holds a response in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
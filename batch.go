@@ -0,0 +1,165 @@
+package surface
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBatch is the most renders in a POST to /api/v1/batch.
+const maxBatch = 100
+
+// extensions are the file name extensions of the media types of
+// renderings.
+var extensions = map[string]string{
+	"image/svg+xml": ".svg", "application/json": ".json", "image/png": ".png",
+	"image/gif": ".gif", "application/pdf": ".pdf", "model/obj": ".obj",
+	"model/stl": ".stl", "model/gltf-binary": ".glb",
+}
+
+// serveBatch answers a POST to /api/v1/batch, a JSON array of the bodies
+// of /api/v1/render, with a zip archive of the renderings. They are
+// rendered by a few workers at a time, and if any fails the batch fails
+// with its errors. The MaxBytes of the request, the largest response body,
+// bounds the renderings together, which are held in memory until the last
+// is done.
+func (h *Handler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		WriteError(w, fmt.Errorf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	var items []json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHeightmapBytes)).Decode(&items); err != nil {
+		WriteError(w, fmt.Errorf("cannot decode JSON batch, want an array of renders: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatch {
+		WriteError(w, fmt.Errorf("batch has %d renders, want 1..%d", len(items), maxBatch), http.StatusBadRequest)
+		return
+	}
+	reqs := make([]apiRequest, len(items))
+	var errs ParamErrors
+	for k, raw := range items {
+		req, err := parseAPIRequest(bytes.NewReader(raw))
+		if err != nil {
+			errs = append(errs, batchErrors(k, err)...)
+		}
+		reqs[k] = req
+	}
+	if len(errs) > 0 {
+		WriteError(w, errs, http.StatusBadRequest)
+		return
+	}
+
+	cfg := h.config.Load()
+	lim := cfg.Limits
+	if cfg.RequestLimits != nil {
+		lim = cfg.RequestLimits(r)
+	}
+	var total atomic.Int64 // bytes of the renderings so far
+	over := func() bool { return lim.MaxBytes > 0 && total.Load() > lim.MaxBytes }
+
+	results := make([]*bufferedResponse, len(reqs))
+	workers := min(len(reqs), runtime.NumCPU())
+	if h.slots != nil {
+		workers = min(workers, cap(h.slots))
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range next {
+				if over() {
+					continue // the batch fails anyway
+				}
+				buf := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+				h.ServeHTTP(buf, reqs[k].request(r))
+				total.Add(int64(buf.body.Len()))
+				results[k] = buf
+			}
+		}()
+	}
+	for k := range reqs {
+		if over() {
+			break
+		}
+		next <- k
+	}
+	close(next)
+	wg.Wait()
+	if over() {
+		WriteError(w, fmt.Errorf("renderings of the batch are larger than the limit of %d bytes", lim.MaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for k, res := range results {
+		if res.status != http.StatusOK {
+			var body struct{ Errors ParamErrors }
+			json.Unmarshal(res.body.Bytes(), &body)
+			WriteError(w, batchErrors(k, body.Errors), res.status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="renders.zip"`)
+	zw := zip.NewWriter(w)
+	used, now := make(map[string]bool), time.Now()
+	for k, res := range results {
+		mediaType := res.header.Get("Content-Type")
+		method := zip.Deflate
+		if mediaType == "image/png" || mediaType == "image/gif" {
+			method = zip.Store // compressed already
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: batchName(reqs[k].name, k, mediaType, used), Method: method, Modified: now})
+		if err != nil {
+			return
+		}
+		f.Write(res.body.Bytes())
+	}
+	zw.Close()
+}
+
+// batchErrors returns the errors of err, of render k of a batch, with
+// their messages saying so.
+func batchErrors(k int, err error) ParamErrors {
+	var errs ParamErrors
+	if pe, ok := err.(ParamErrors); ok {
+		errs = pe
+	} else {
+		errs = ParamErrors{{Message: err.Error()}}
+	}
+	out := make(ParamErrors, len(errs))
+	for j, e := range errs {
+		out[j] = ParamError{Param: e.Param, Message: fmt.Sprintf("render %d: %s", k, e.Message)}
+	}
+	return out
+}
+
+// batchName returns the file name of render k of a batch: name, kept to
+// its last element, or render-k, with the extension of mediaType, and made
+// unique among those in used.
+func batchName(name string, k int, mediaType string, used map[string]bool) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = fmt.Sprintf("render-%d", k)
+	}
+	ext := extensions[mediaType]
+	name = strings.TrimSuffix(name, ext)
+	unique := name + ext
+	for n := 2; used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d%s", name, n, ext)
+	}
+	used[unique] = true
+	return unique
+}
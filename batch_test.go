@@ -0,0 +1,38 @@
+package surface
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postBatch serves a POST of the JSON batch body by h.
+func postBatch(h http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/batch", strings.NewReader(body)))
+	return w
+}
+
+func TestBatchMaxBytes(t *testing.T) {
+	size := int64(get(t, NewHandler(HandlerConfig{}), "/?cells=10").Body.Len())
+	h := NewHandler(HandlerConfig{Limits: Limits{MaxBytes: 2 * size}})
+	w := postBatch(h, `[{"cells": 10, "name": "a"}, {"cells": 10, "name": "b"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch within the limit: status %d: %s", w.Code, w.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "a.svg" || zr.File[1].Name != "b.svg" {
+		t.Errorf("archive of %d files, want a.svg and b.svg", len(zr.File))
+	}
+	// Each rendering is within the limit, but not the three together.
+	w = postBatch(h, `[{"cells": 10}, {"cells": 10}, {"cells": 10}]`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("batch over the limit: status %d, want 413", w.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package surface
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderCache is an LRU cache of rendered responses. Renders are pure
+// functions of their parameters, so entries never need invalidating.
+type renderCache struct {
+	mu       sync.Mutex
+	maxBytes int // total body size the cache may hold
+	bytes    int
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	body []byte
+	etag string // strong entity tag of the uncompressed body
+}
+
+func newRenderCache(maxBytes int) *renderCache {
+	return &renderCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// newCacheEntry returns an entry for body, deriving its entity tag from
+// the content.
+func newCacheEntry(key string, body []byte) *cacheEntry {
+	sum := sha256.Sum256(body)
+	return &cacheEntry{key: key, body: body, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+}
+
+func (c *renderCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry), true
+}
+
+// size returns the total body size of the entries.
+func (c *renderCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// add stores e, evicting the least recently used entries to make room.
+// Entries larger than the whole cache are not stored.
+func (c *renderCache) add(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(e.body) > c.maxBytes {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.bytes -= len(el.Value.(*cacheEntry).body)
+		c.lru.Remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += len(e.body)
+	for c.bytes > c.maxBytes {
+		old := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, old.key)
+		c.bytes -= len(old.body)
+	}
+}
+
+// cacheKey returns the key for the response to r, or "" if the response
+// must not be cached. The query is normalized so that equivalent URLs
+// share an entry.
+func cacheKey(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "" // uploads such as heightmaps are not part of the key
+	}
+	return r.URL.Path + "?" + normalizeQuery(r.URL.Query()).Encode()
+}
+
+// listParameters are the parameters of which ParseQuery reads every value,
+// as listValues splits them: the functions drawn together and the colors
+// of each.
+var listParameters = map[string]bool{"function": true, "colormap": true, "fill-opacity": true}
+
+// normalizeQuery returns the parameters of q that ParseQuery reads, and the
+// function.param tunings, with only the first of repeated values, which is
+// the one it reads, except for the listParameters, and with numbers,
+// booleans, colors and durations in a canonical form. Values that do not
+// parse are kept as they are, to fail as they would have.
+func normalizeQuery(q url.Values) url.Values {
+	n := make(url.Values)
+	for _, p := range parameters {
+		if !q.Has(p.Name) {
+			continue
+		}
+		if listParameters[p.Name] {
+			vs := listValues(q, p.Name)
+			for k, v := range vs {
+				vs[k] = canonicalValue(p.Type, v)
+			}
+			n.Set(p.Name, strings.Join(vs, ","))
+			continue
+		}
+		n.Set(p.Name, canonicalValue(p.Type, q.Get(p.Name)))
+	}
+	for key := range q {
+		if strings.Contains(key, ".") { // function.param, as parseTunings reads
+			n.Set(key, canonicalValue("float", q.Get(key)))
+		}
+	}
+	return n
+}
+
+// canonicalValue returns v, a value of type typ, in a canonical form, or
+// as it is if it does not parse.
+func canonicalValue(typ, v string) string {
+	switch typ {
+	case "bool":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case "int":
+		if i, err := strconv.Atoi(v); err == nil {
+			return strconv.Itoa(i)
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case "color":
+		if c, err := parseColor(v); err == nil {
+			return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+		}
+	case "duration":
+		if d, err := time.ParseDuration(v); err == nil {
+			return d.String()
+		}
+	}
+	return v
+}
+
+// etagMatch reports whether the If-None-Match header of r lists etag.
+func etagMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
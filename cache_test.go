@@ -0,0 +1,84 @@
+package surface
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// get serves a GET of target by h and returns the response.
+func get(t *testing.T, h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k := 0; k+1 < len(header); k += 2 {
+		r.Header.Set(header[k], header[k+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK && w.Code != http.StatusNotModified {
+		t.Fatalf("GET %s: status %d: %s", target, w.Code, w.Body)
+	}
+	return w
+}
+
+func TestCacheKeySeparatesLayers(t *testing.T) {
+	h := NewHandler(HandlerConfig{CacheBytes: 64 << 20})
+	targets := []string{
+		"/?function=sin&cells=20",
+		"/?function=sin&function=saddle&cells=20",
+		"/?function=sin,saddle&colormap=viridis,magma&cells=20",
+		"/?function=sin,saddle&colormap=viridis,plasma&cells=20",
+		"/?function=sin,saddle&fill-opacity=1,0.5&cells=20",
+	}
+	etags := make(map[string]string)
+	for _, target := range targets {
+		w := get(t, h, target)
+		etag := w.Header().Get("ETag")
+		if other, ok := etags[etag]; ok {
+			t.Errorf("%s has the ETag of %s", target, other)
+		}
+		etags[etag] = target
+	}
+	// Served again, each from the cache, in the other order.
+	for k := len(targets) - 1; k >= 0; k-- {
+		w := get(t, h, targets[k])
+		if got := etags[w.Header().Get("ETag")]; got != targets[k] {
+			t.Errorf("%s served the render of %s", targets[k], got)
+		}
+	}
+}
+
+func TestCacheKeyListsEqual(t *testing.T) {
+	a := normalizeQuery(mustQuery(t, "function=sin&function=saddle&fill-opacity=1.0&fill-opacity=.5"))
+	b := normalizeQuery(mustQuery(t, "function=sin,%20saddle&fill-opacity=1,0.5"))
+	if a.Encode() != b.Encode() {
+		t.Errorf("keys differ: %s and %s", a.Encode(), b.Encode())
+	}
+	if got := a.Get("function"); got != "sin,saddle" {
+		t.Errorf("function = %q, want sin,saddle", got)
+	}
+}
+
+func mustQuery(t *testing.T, s string) url.Values {
+	t.Helper()
+	q, err := url.ParseQuery(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestCacheKeySeparatesTunings(t *testing.T) {
+	h := NewHandler(HandlerConfig{CacheBytes: 64 << 20})
+	plain := get(t, h, "/?function=eggbox&cells=20")
+	tuned := get(t, h, "/?function=eggbox&cells=20&eggbox.amp=0.9")
+	if plain.Header().Get("ETag") == tuned.Header().Get("ETag") {
+		t.Error("the tuned render has the ETag of the plain one")
+	}
+	a := normalizeQuery(mustQuery(t, "function=eggbox&eggbox.amp=0.90"))
+	b := normalizeQuery(mustQuery(t, "function=eggbox&eggbox.amp=.9"))
+	if a.Encode() != b.Encode() {
+		t.Errorf("keys differ: %s and %s", a.Encode(), b.Encode())
+	}
+}
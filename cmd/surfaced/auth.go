@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mxschardt/surface"
+)
+
+// apiKey is an entry of the -keys file, such as
+//
+//	{"key": "s3cret", "rate": 2, "burst": 5, "maxcanvas": 1000}
+//
+// The limits override those of the server flags where set, and the rate
+// and burst apply to all requests with the key together.
+type apiKey struct {
+	Key       string  `json:"key"`
+	Rate      float64 `json:"rate"`
+	Burst     int     `json:"burst"`
+	MaxCanvas int     `json:"maxcanvas"`
+	MaxCells  int     `json:"maxcells"`
+	MaxBytes  int64   `json:"maxbytes"`
+
+	limiter *limiter // nil without a rate
+}
+
+// apiKeyContext is the request context key of the *apiKey of a request.
+type apiKeyContext struct{}
+
+// loadKeys reads the JSON array of API keys in the file at path.
+func loadKeys(path string) ([]*apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keys: %v", err)
+	}
+	var keys []*apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("reading keys %s: %v", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("reading keys %s: no keys", path)
+	}
+	for n, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("reading keys %s: key %d is empty", path, n)
+		}
+		if k.Rate < 0 || k.Burst < 0 || k.MaxCanvas < 0 || k.MaxCells < 0 || k.MaxBytes < 0 {
+			return nil, fmt.Errorf("reading keys %s: key %d has a negative limit", path, n)
+		}
+		if k.MaxCanvas > surface.MaxCanvas || k.MaxCells > surface.MaxCells {
+			return nil, fmt.Errorf("reading keys %s: key %d allows more than %d pixels or %d cells", path, n, surface.MaxCanvas, surface.MaxCells)
+		}
+		if k.Rate > 0 {
+			k.limiter = newLimiter(k.Rate, k.Burst)
+		}
+	}
+	return keys, nil
+}
+
+// authenticate passes to h only the requests that present one of keys, in
+// an X-API-Key header or a key query parameter, which is then removed so
+// it stays out of logs. Without keys it is h.
+func authenticate(keys []*apiKey, h http.Handler) http.Handler {
+	if len(keys) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		presented := r.Header.Get("X-API-Key")
+		if presented == "" {
+			presented = q.Get("key")
+		}
+		k := findKey(keys, presented)
+		if k == nil {
+			msg := "missing API key: set the X-API-Key header or the 'key' parameter"
+			if presented != "" {
+				msg = "unknown API key"
+			}
+			surface.WriteError(w, errors.New(msg), http.StatusUnauthorized)
+			return
+		}
+		if k.limiter != nil {
+			if ok, wait := k.limiter.allow("", time.Now()); !ok {
+				tooManyRequests(w, wait, fmt.Errorf("too many requests with this API key, at most %g a second", k.Rate))
+				return
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyContext{}, k))
+		if q.Has("key") {
+			u := *r.URL
+			q.Del("key")
+			u.RawQuery = q.Encode()
+			r.URL = &u
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// keyLimits returns the limits of a request: those of its API key where
+// set, else lim.
+func keyLimits(lim surface.Limits) func(*http.Request) surface.Limits {
+	return func(r *http.Request) surface.Limits {
+		k, ok := r.Context().Value(apiKeyContext{}).(*apiKey)
+		if !ok {
+			return lim
+		}
+		l := lim
+		if k.MaxCanvas > 0 {
+			l.MaxCanvas = k.MaxCanvas
+		}
+		if k.MaxCells > 0 {
+			l.MaxCells = k.MaxCells
+		}
+		if k.MaxBytes > 0 {
+			l.MaxBytes = k.MaxBytes
+		}
+		return l
+	}
+}
+
+// findKey returns the entry of keys for s, comparing in constant time.
+func findKey(keys []*apiKey, s string) *apiKey {
+	var found *apiKey
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(s)) == 1 {
+			found = k
+		}
+	}
+	return found
+}
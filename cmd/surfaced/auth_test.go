@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mxschardt/surface"
+)
+
+func TestAuthenticate(t *testing.T) {
+	keys := []*apiKey{{Key: "s3cret"}, {Key: "other"}}
+	var seen string // query of the last request passed on
+	h := authenticate(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.RawQuery
+	}))
+	for _, c := range []struct {
+		target, header string
+		want           int
+	}{
+		{"/?cells=5", "", http.StatusUnauthorized},
+		{"/?cells=5&key=wrong", "", http.StatusUnauthorized},
+		{"/?cells=5", "wrong", http.StatusUnauthorized},
+		{"/?cells=5&key=", "", http.StatusUnauthorized},
+		{"/?cells=5&key=s3cret", "", http.StatusOK},
+		{"/?cells=5&key=other", "", http.StatusOK},
+		{"/?cells=5", "s3cret", http.StatusOK},
+		{"/?cells=5&key=wrong", "s3cret", http.StatusOK}, // the header comes first
+	} {
+		seen = ""
+		r := httptest.NewRequest(http.MethodGet, c.target, nil)
+		if c.header != "" {
+			r.Header.Set("X-API-Key", c.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s with X-API-Key %q: status %d, want %d", c.target, c.header, w.Code, c.want)
+		}
+		if c.want == http.StatusOK && seen != "cells=5" {
+			t.Errorf("%s with X-API-Key %q: handler saw the query %q, want cells=5", c.target, c.header, seen)
+		}
+	}
+}
+
+func TestAuthenticateHidesKey(t *testing.T) {
+	// The key reaches neither the cache key nor the metadata of the render.
+	h := authenticate([]*apiKey{{Key: "s3cret"}}, surface.NewHandler(surface.HandlerConfig{CacheBytes: 1 << 20}))
+	var etags []string
+	for _, target := range []string{"/?cells=5&key=s3cret", "/?key=s3cret&cells=5"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "s3cret") {
+			t.Errorf("%s: the render has the key", target)
+		}
+		etags = append(etags, w.Header().Get("ETag"))
+	}
+	if etags[0] != etags[1] {
+		t.Errorf("ETags %s and %s differ", etags[0], etags[1])
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mxschardt/surface"
+)
+
+// reloadable are the flags that a reloaded config file changes.
+var reloadable = []string{"maxcanvas", "maxcells", "maxbytes", "maxmem", "timeout"}
+
+// config is the part of the configuration the handler reads per request.
+type config struct {
+	limits    surface.Limits
+	defaults  url.Values // parameters of renders that leave them out
+	functions []string   // the functions requests may ask for; nil means all
+}
+
+// explicit holds the names of the flags set on the command line or by
+// environment variables, which the config file does not override.
+var explicit = make(map[string]bool)
+
+// applyEnvironment sets the flags not on the command line from their
+// SURFACED_<NAME> environment variables. It is called once, after
+// flag.Parse.
+func applyEnvironment() error {
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		env := "SURFACED_" + strings.ToUpper(f.Name)
+		v, ok := os.LookupEnv(env)
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if e := f.Value.Set(v); e != nil {
+			err = fmt.Errorf("%s=%q: %v", env, v, e)
+		}
+		explicit[f.Name] = true
+	})
+	return err
+}
+
+// loadConfig reads the -config file at path, or none if path is empty. The
+// file sets flags by name, the default query parameters of renders in a
+// [defaults] section, and the functions requests may ask for, in a subset
+// of TOML: strings are quoted and lists are of strings.
+//
+//	addr = ":8000"
+//	maxcells = 500
+//	functions = ["sin", "eggbox", "expr"]
+//
+//	[defaults]
+//	cells = 200
+//	colormap = "viridis"
+//
+// Flags on the command line or from the environment take precedence over
+// the file. Only with startup set are flags other than the reloadable ones
+// set, as the server reads them without synchronization once it runs.
+func loadConfig(path string, startup bool) (*config, error) {
+	file := map[string]map[string]string{"": {}}
+	if path != "" {
+		var err error
+		if file, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+	c := &config{defaults: make(url.Values)}
+
+	// The reloadable flags are parsed afresh into a flag set of their own.
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.IntVar(&c.limits.MaxCanvas, "maxcanvas", 0, "")
+	fs.IntVar(&c.limits.MaxCells, "maxcells", 0, "")
+	fs.Int64Var(&c.limits.MaxBytes, "maxbytes", 0, "")
+	fs.Int64Var(&c.limits.MaxMem, "maxmem", 0, "")
+	fs.DurationVar(&c.limits.Timeout, "timeout", 0, "")
+	for _, name := range reloadable {
+		f := flag.Lookup(name)
+		v, ok := file[""][name]
+		if explicit[name] || !ok {
+			v = f.Value.String()
+			if !explicit[name] {
+				v = f.DefValue
+			}
+		}
+		if err := fs.Set(name, v); err != nil {
+			return nil, fmt.Errorf("%s: %s = %q: %v", path, name, v, err)
+		}
+	}
+
+	for name, v := range file[""] {
+		if name == "functions" {
+			c.functions = strings.Split(v, ",")
+			continue
+		}
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if !startup || explicit[name] || slices.Contains(reloadable, name) {
+			continue
+		}
+		if err := f.Value.Set(v); err != nil {
+			return nil, fmt.Errorf("%s: %s = %q: %v", path, name, v, err)
+		}
+	}
+	for name := range file {
+		if name != "" && name != "defaults" {
+			return nil, fmt.Errorf("%s: unknown section [%s]", path, name)
+		}
+	}
+	for name, v := range file["defaults"] {
+		c.defaults.Set(name, v)
+	}
+	if _, _, err := surface.ParseQuery(c.defaults); err != nil {
+		return nil, fmt.Errorf("%s: [defaults]: %v", path, err)
+	}
+	for _, fn := range c.functions {
+		fn = strings.ToLower(strings.TrimSpace(fn))
+		if _, ok := surface.LookupProjector(fn); !ok && fn != "expr" && fn != "heightmap" {
+			return nil, fmt.Errorf("%s: unknown function %q", path, fn)
+		}
+	}
+	return c, nil
+}
+
+// readConfigFile parses the file at path into the values of each section,
+// the top level being "". List values are joined with commas.
+func readConfigFile(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file := map[string]map[string]string{"": {}}
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if file[section] == nil {
+				file[section] = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want key = value, got %q", path, n, line)
+		}
+		v, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, n, key, err)
+		}
+		file[section][key] = v
+	}
+	return file, sc.Err()
+}
+
+// parseConfigValue parses a quoted string, a list of them, or a bare
+// number or bool, ignoring a trailing comment.
+func parseConfigValue(s string) (string, error) {
+	if strings.HasPrefix(s, "[") {
+		end := strings.LastIndex(s, "]")
+		if end < 0 {
+			return "", errors.New("unterminated list")
+		}
+		var items []string
+		for _, item := range strings.Split(s[1:end], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := strconv.Unquote(item)
+			if err != nil {
+				return "", fmt.Errorf("list item %s is not a quoted string", item)
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		end := strings.Index(s[1:], `"`) + 1
+		if end == 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(s[:end+1])
+	}
+	if k := strings.Index(s, "#"); k >= 0 {
+		s = strings.TrimSpace(s[:k])
+	}
+	if s == "" {
+		return "", errors.New("missing value")
+	}
+	return s, nil
+}
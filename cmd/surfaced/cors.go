@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsHeaders are the request headers browsers may send cross-origin.
+const corsHeaders = "Content-Type, If-None-Match, X-API-Key"
+
+// corsExposed are the response headers, past the safelisted ones, that
+// pages may read: the entity tag, how long to back off, the ID of the
+// request in the log, and the file name of a download.
+const corsExposed = "ETag, Retry-After, X-Request-ID, Content-Disposition"
+
+// cors lets pages from the origins in -corsorigins call h: it answers
+// preflight requests itself, and marks the responses to allowed origins as
+// readable by them. Without -corsorigins it is h.
+func cors(h http.Handler) http.Handler {
+	origins := corsOrigins()
+	if len(origins) == 0 {
+		return h
+	}
+	all := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !all && !slices.Contains(origins, origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr := w.Header()
+		if all {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+			hdr.Set("Access-Control-Allow-Methods", *corsMethodsFlag)
+			hdr.Set("Access-Control-Allow-Headers", corsHeaders)
+			if *corsMaxAgeFlag > 0 {
+				hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAgeFlag.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		hdr.Set("Access-Control-Expose-Headers", corsExposed)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// corsOrigins returns the origins of -corsorigins, which may also open
+// WebSockets.
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range splitList(*corsOriginsFlag) {
+		origins = append(origins, strings.TrimSuffix(o, "/"))
+	}
+	return origins
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setFlag sets *f to v for the rest of the test.
+func setFlag[T any](t *testing.T, f *T, v T) {
+	old := *f
+	*f = v
+	t.Cleanup(func() { *f = old })
+}
+
+func TestCORS(t *testing.T) {
+	setFlag(t, corsOriginsFlag, "https://plots.example/, https://other.example")
+	setFlag(t, corsMethodsFlag, "GET, HEAD")
+	setFlag(t, corsMaxAgeFlag, 10*time.Minute)
+	served := false
+	h := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		w.Header().Set("X-Request-ID", "0123456789abcdef")
+	}))
+	serve := func(method, origin string, header ...string) *httptest.ResponseRecorder {
+		served = false
+		r := httptest.NewRequest(method, "/?cells=5", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k := 0; k+1 < len(header); k += 2 {
+			r.Header.Set(header[k], header[k+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodOptions, "https://plots.example", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "x-api-key")
+	if w.Code != http.StatusNoContent || served {
+		t.Errorf("preflight: status %d, served %v; want 204 answered by cors", w.Code, served)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://plots.example",
+		"Access-Control-Allow-Methods": "GET, HEAD",
+		"Access-Control-Allow-Headers": corsHeaders,
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("preflight %s %q, want %q", name, got, want)
+		}
+	}
+	if got := w.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("preflight Vary %q, want Origin and the two request headers", got)
+	}
+
+	w = serve(http.MethodGet, "https://other.example")
+	if !served || w.Header().Get("Access-Control-Allow-Origin") != "https://other.example" {
+		t.Errorf("allowed origin: served %v, Allow-Origin %q", served, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if got, want := w.Header().Get("Access-Control-Expose-Headers"), "ETag, Retry-After, X-Request-ID, Content-Disposition"; got != want {
+		t.Errorf("Expose-Headers %q, want %q", got, want)
+	}
+
+	for _, origin := range []string{"", "https://evil.example"} {
+		w = serve(http.MethodGet, origin)
+		if !served || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("origin %q: served %v, Allow-Origin %q; want served without CORS headers", origin, served, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+	// A preflight from another origin gets no permission from cors.
+	if w = serve(http.MethodOptions, "https://evil.example", "Access-Control-Request-Method", "GET"); !served || w.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("preflight of another origin: served %v, Allow-Methods %q", served, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
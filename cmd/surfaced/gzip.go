@@ -0,0 +1,116 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipHandler compresses the responses of h with gzip for clients that
+// accept it, except for content types that are compressed already. Each
+// content coding is a different representation, so the entity tags of
+// compressed responses gain a "-gzip" suffix, which is removed from the
+// If-None-Match header of requests before h compares them to its own.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if tags := r.Header.Get("If-None-Match"); tags != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", strings.ReplaceAll(tags, `-gzip"`, `"`))
+		}
+		gw := &gzipWriter{ResponseWriter: w, head: r.Method == http.MethodHead}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// precompressed are the content types not worth compressing again.
+var precompressed = map[string]bool{
+	"image/png":         true,
+	"image/gif":         true,
+	"application/zip":   true,
+	"model/gltf-binary": true,
+}
+
+// gzipWriter compresses the body written to it, if its headers allow by
+// the time they are written.
+type gzipWriter struct {
+	http.ResponseWriter
+	head        bool // the response has no body
+	wroteHeader bool
+	gzipped     bool // the body is compressed
+	zw          *gzip.Writer
+}
+
+func (g *gzipWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if h.Get("Content-Encoding") == "" && !precompressed[h.Get("Content-Type")] {
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+		}
+		if status != http.StatusNotModified && status != http.StatusNoContent {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			g.gzipped = true
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	g.WriteHeader(http.StatusOK)
+	if !g.gzipped {
+		return g.ResponseWriter.Write(p)
+	}
+	if g.zw == nil {
+		g.zw = gzip.NewWriter(g.ResponseWriter)
+	}
+	return g.zw.Write(p)
+}
+
+// Flush sends the body written so far to the client, compressing what the
+// gzip writer holds back.
+func (g *gzipWriter) Flush() {
+	g.WriteHeader(http.StatusOK)
+	if g.zw != nil {
+		g.zw.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// close writes the headers, if h did not, and ends the compressed body.
+func (g *gzipWriter) close() {
+	g.WriteHeader(http.StatusOK)
+	if g.gzipped && g.zw == nil && !g.head {
+		g.zw = gzip.NewWriter(g.ResponseWriter) // an empty body
+	}
+	if g.zw != nil {
+		g.zw.Close()
+	}
+}
+
+// acceptsGzip reports whether the client accepts a gzip-encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mxschardt/surface"
+)
+
+func TestGzipHandler(t *testing.T) {
+	h := gzipHandler(surface.NewHandler(surface.HandlerConfig{}))
+	serve := func(encoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/?cells=20", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Accept-Encoding %q: status %d", encoding, w.Code)
+		}
+		return w
+	}
+	plain, gzipped := serve(""), serve("gzip")
+	if got := plain.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("plain response has Content-Encoding %q", got)
+	}
+	if got := gzipped.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(gzipped.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Error("the decompressed body differs from the plain one")
+	}
+	if plain.Header().Get("ETag") == gzipped.Header().Get("ETag") {
+		t.Error("the gzipped response has the ETag of the plain one")
+	}
+}
+
+func TestGzipFlush(t *testing.T) {
+	release := make(chan struct{})
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "frame 1")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Error(err)
+		}
+		<-release
+		io.WriteString(w, ", frame 2")
+	}))
+	// Wrapped as the request log wraps it.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&responseRecorder{ResponseWriter: w, status: http.StatusOK}, r)
+	}))
+	defer srv.Close()
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The first frame arrives while the handler waits to write the second.
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("frame 1"))
+	if _, err := io.ReadFull(zr, first); err != nil || string(first) != "frame 1" {
+		t.Fatalf("before the handler returned: %q %v, want frame 1", first, err)
+	}
+	release <- struct{}{}
+	rest, err := io.ReadAll(zr)
+	if err != nil || string(rest) != ", frame 2" {
+		t.Errorf("rest of the body %q %v, want , frame 2", rest, err)
+	}
+}
+
+func TestGzipPrecompressed(t *testing.T) {
+	for typ, want := range map[string]bool{
+		"image/png":         false,
+		"image/gif":         false,
+		"application/zip":   false,
+		"model/gltf-binary": false,
+		"image/svg+xml":     true,
+	} {
+		h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", typ)
+			io.WriteString(w, "body")
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding") == "gzip"; got != want {
+			t.Errorf("%s: gzipped %v, want %v", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// logger is the structured log of the server, in the format set by -log.
+// Records logged with the context of a request carry its ID.
+var logger = slog.New(requestIDHandler{slog.NewTextHandler(os.Stderr, nil)})
+
+// requestIDContext is the request context key of the ID of a request.
+type requestIDContext struct{}
+
+// requestIDHandler adds the request ID in the context of each record, if
+// there is one, as its first attribute.
+type requestIDHandler struct{ slog.Handler }
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	id, ok := ctx.Value(requestIDContext{}).(string)
+	if !ok {
+		return h.Handler.Handle(ctx, r)
+	}
+	rec := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	rec.AddAttrs(slog.String("id", id))
+	r.Attrs(func(a slog.Attr) bool {
+		rec.AddAttrs(a)
+		return true
+	})
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// logRequests logs every request to h once it has been handled, with its
+// method, parameters, status, duration and body size, under a request ID
+// that is also returned in the X-Request-ID header. A well-formed ID sent
+// by the client or a gateway in that header is kept.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContext{}, id))
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		q := r.URL.Query()
+		if q.Has("key") {
+			q.Set("key", "REDACTED")
+		}
+		logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"params", q.Encode(),
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+			"remote", clientIP(r))
+	})
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,204 @@
+// Command surfaced serves SVG renderings of 3-D surface functions over HTTP.
+//
+// With the render subcommand it instead writes a single rendering to a file;
+// run "surfaced render -help" for details.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mxschardt/surface"
+)
+
+var (
+	configFlag         = flag.String("config", "", "file of settings and default parameters, reloaded on SIGHUP")
+	addrFlag           = flag.String("addr", "localhost:8000", "address to listen on")
+	tlsCertFlag        = flag.String("tlscert", "", "certificate file, with -tlskey, to serve HTTPS")
+	tlsKeyFlag         = flag.String("tlskey", "", "private key file of -tlscert")
+	readTimeoutFlag    = flag.Duration("readtimeout", time.Minute, "longest to read a request, body included; 0 means no limit")
+	writeTimeoutFlag   = flag.Duration("writetimeout", 5*time.Minute, "longest to write a response, from the end of the request headers; 0 means no limit")
+	metricsFlag        = flag.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+	maxMemFlag         = flag.Int64("maxmem", 256<<20, "largest mesh in bytes a single request may sample")
+	cacheFlag          = flag.Int("cache", 64<<20, "size in bytes of the cache of rendered responses")
+	timeoutFlag        = flag.Duration("timeout", 0, "longest a single render may take; 0 means no limit")
+	maxCanvasFlag      = flag.Int("maxcanvas", surface.MaxCanvas, "largest width or height in pixels a request may ask for")
+	maxCellsFlag       = flag.Int("maxcells", surface.MaxCells, "most grid cells per side a request may ask for")
+	maxBytesFlag       = flag.Int64("maxbytes", 64<<20, "largest response body in bytes; 0 means no limit")
+	concurrencyFlag    = flag.Int("concurrency", 2*runtime.NumCPU(), "most renders in progress at once; 0 means no limit")
+	rateFlag           = flag.Float64("rate", 0, "requests a second each client address may make; 0 means no limit")
+	burstFlag          = flag.Int("burst", 10, "requests a client address may make at once, above -rate")
+	globalRateFlag     = flag.Float64("globalrate", 0, "requests a second all clients together may make; 0 means no limit")
+	globalBurstFlag    = flag.Int("globalburst", 50, "requests all clients together may make at once, above -globalrate")
+	logFlag            = flag.String("log", "text", "format of the request log: text or json")
+	otlpFlag           = flag.String("otlp", "", "OTLP/HTTP traces endpoint, such as http://localhost:4318/v1/traces, to export spans to; empty means none")
+	unreadyFlag        = flag.Duration("unready", 5*time.Second, "how long to go on serving on shutdown after /readyz fails, for load balancers to notice")
+	drainFlag          = flag.Duration("drain", 30*time.Second, "longest to wait on shutdown for the renders in progress to finish")
+	debugFlag          = flag.String("debug", "", "address, such as localhost:6060, at which to serve net/http/pprof profiles; empty means none")
+	heightmapHostsFlag = flag.String("heightmaphosts", "", "comma-separated hosts from which function=heightmap may fetch the heights at a 'heightmap' URL")
+	keysFlag           = flag.String("keys", "", "JSON file of the API keys requests must present; empty means none are needed")
+	corsOriginsFlag    = flag.String("corsorigins", "", "comma-separated origins, or *, whose pages may fetch renders")
+	corsMethodsFlag    = flag.String("corsmethods", "GET, HEAD, POST", "methods pages from -corsorigins may use")
+	corsMaxAgeFlag     = flag.Duration("corsmaxage", 10*time.Minute, "how long browsers may cache a preflight response")
+)
+
+// renderer serves the renders.
+var renderer *surface.Handler
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := renderCommand(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+	flag.Parse()
+	if err := applyEnvironment(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	c, err := loadConfig(*configFlag, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	switch *logFlag {
+	case "text":
+	case "json":
+		logger = slog.New(requestIDHandler{slog.NewJSONHandler(os.Stderr, nil)})
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -log format %q, want text or json\n", *logFlag)
+		os.Exit(2)
+	}
+	var keys []*apiKey
+	if *keysFlag != "" {
+		var err error
+		if keys, err = loadKeys(*keysFlag); err != nil {
+			fatal(err)
+		}
+	}
+	renderer = surface.NewHandler(handlerConfig(c))
+	// The server has a mux of its own so that the profiles registered with
+	// http.DefaultServeMux by net/http/pprof are only served by -debug.
+	mux := http.NewServeMux()
+	mux.Handle("/", cors(rateLimit(authenticate(keys, gzipHandler(renderMetrics.countInFlight(renderer))))))
+	if *metricsFlag {
+		mux.Handle("/metrics", renderMetrics)
+	}
+	mux.HandleFunc("/ui", uiHandler)
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	if *debugFlag != "" {
+		go func() {
+			logger.Info("serving profiles", "url", "http://"+*debugFlag+"/debug/pprof/")
+			fatal(http.ListenAndServe(*debugFlag, http.DefaultServeMux))
+		}()
+	}
+	if (*tlsCertFlag == "") != (*tlsKeyFlag == "") {
+		fatal(errors.New("set both -tlscert and -tlskey, or neither"))
+	}
+	ln, err := net.Listen("tcp", *addrFlag)
+	if err != nil {
+		fatal(err)
+	}
+	srv := &http.Server{
+		Handler:           logRequests(traceRequests(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeoutFlag,
+		WriteTimeout:      *writeTimeoutFlag,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	// On SIGHUP, read the config file again.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			c, err := loadConfig(*configFlag, false)
+			if err != nil {
+				logger.Error("reloading config", "error", err)
+				continue
+			}
+			renderer.SetConfig(handlerConfig(c))
+			logger.Info("reloaded config", "file", *configFlag)
+		}
+	}()
+
+	// On SIGINT or SIGTERM, fail readiness probes but go on serving for
+	// -unready, or until a second signal, so that load balancers stop
+	// sending requests first. Then stop accepting connections and let the
+	// renders in progress finish, for up to -drain, before exiting.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		sig := <-stop
+		ready.Store(false)
+		logger.Info("shutting down", "signal", sig.String(), "unready", *unreadyFlag, "drain", *drainFlag)
+		select {
+		case <-time.After(*unreadyFlag):
+		case <-stop:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *drainFlag)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("renders still in progress after draining", "error", err)
+			srv.Close()
+		}
+		close(done)
+	}()
+
+	ready.Store(true)
+	logger.Info("listening", "addr", ln.Addr().String(), "tls", *tlsCertFlag != "")
+	if *tlsCertFlag != "" {
+		err = srv.ServeTLS(ln, *tlsCertFlag, *tlsKeyFlag)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		fatal(err)
+	}
+	<-done
+}
+
+// handlerConfig returns the configuration of the renderer by c and the
+// flags.
+func handlerConfig(c *config) surface.HandlerConfig {
+	return surface.HandlerConfig{
+		Limits:         c.limits,
+		RequestLimits:  keyLimits(c.limits),
+		Defaults:       c.defaults,
+		Functions:      c.functions,
+		HeightmapHosts: splitList(*heightmapHostsFlag),
+		Origins:        corsOrigins(),
+		CacheBytes:     *cacheFlag,
+		MaxConcurrent:  *concurrencyFlag,
+		Logger:         logger,
+		Trace:          func(r *http.Request) func(string) func() { return renderTrace(requestSpan(r)) },
+		Report:         renderMetrics.report,
+	}
+}
+
+// splitList returns the non-empty elements of the comma-separated list s.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
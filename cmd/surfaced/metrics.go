@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mxschardt/surface"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the render duration
+// histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// sizeBuckets are the upper bounds, in bytes, of the response size
+// histogram.
+var sizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+// metrics aggregates per-function render statistics, and those of the
+// server as a whole, and serves them in the Prometheus text exposition
+// format.
+type metrics struct {
+	mu                     sync.Mutex
+	functions              map[string]*functionMetrics
+	cacheHits, cacheMisses uint64
+	inFlight               atomic.Int64 // requests being handled
+}
+
+type functionMetrics struct {
+	requests, errors uint64
+	polygons, bytes  uint64
+	buckets          []uint64 // cumulative counts per durationBuckets entry
+	seconds          float64  // sum of render durations
+	sizeBuckets      []uint64 // cumulative counts per sizeBuckets entry
+}
+
+var renderMetrics = &metrics{functions: make(map[string]*functionMetrics)}
+
+// observe records one request for function.
+func (m *metrics) observe(function string, d time.Duration, polygons, bytes int, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.functions[function]
+	if f == nil {
+		f = &functionMetrics{
+			buckets:     make([]uint64, len(durationBuckets)),
+			sizeBuckets: make([]uint64, len(sizeBuckets)),
+		}
+		m.functions[function] = f
+	}
+	f.requests++
+	if failed {
+		f.errors++
+	}
+	f.polygons += uint64(polygons)
+	f.bytes += uint64(bytes)
+	f.seconds += d.Seconds()
+	for k, le := range durationBuckets {
+		if d.Seconds() <= le {
+			f.buckets[k]++
+		}
+	}
+	for k, le := range sizeBuckets {
+		if float64(bytes) <= le {
+			f.sizeBuckets[k]++
+		}
+	}
+}
+
+// report records a render request handled by the renderer.
+func (m *metrics) report(r *http.Request, rep surface.Report) {
+	failed := rep.Aborted || rep.Status >= http.StatusBadRequest
+	m.observe(rep.Function, rep.Duration, rep.Stats.Polygons, rep.Bytes, failed)
+	if rep.Cacheable {
+		m.cacheLookup(rep.Cached)
+	}
+}
+
+// countInFlight counts the render requests to h while they are handled.
+func (m *metrics) countInFlight(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/functions" {
+			m.inFlight.Add(1)
+			defer m.inFlight.Add(-1)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cacheLookup records a lookup in the render cache.
+func (m *metrics) cacheLookup(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.functions))
+	for name := range m.functions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	counter := func(metric, help string, value func(*functionMetrics) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{function=%q} %d\n", metric, name, value(m.functions[name]))
+		}
+	}
+	counter("surface_requests_total", "Render requests by function.",
+		func(f *functionMetrics) uint64 { return f.requests })
+	counter("surface_errors_total", "Rejected or aborted render requests by function.",
+		func(f *functionMetrics) uint64 { return f.errors })
+	counter("surface_polygons_total", "Polygons rendered by function.",
+		func(f *functionMetrics) uint64 { return f.polygons })
+	counter("surface_response_bytes_total", "Response body bytes written by function.",
+		func(f *functionMetrics) uint64 { return f.bytes })
+
+	histogram := func(metric, help string, bounds []float64, counts func(*functionMetrics) []uint64, sum func(*functionMetrics) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", metric, help, metric)
+		for _, name := range names {
+			f := m.functions[name]
+			for k, le := range bounds {
+				fmt.Fprintf(w, "%s_bucket{function=%q,le=\"%g\"} %d\n", metric, name, le, counts(f)[k])
+			}
+			fmt.Fprintf(w, "%s_bucket{function=%q,le=\"+Inf\"} %d\n", metric, name, f.requests)
+			fmt.Fprintf(w, "%s_sum{function=%q} %g\n", metric, name, sum(f))
+			fmt.Fprintf(w, "%s_count{function=%q} %d\n", metric, name, f.requests)
+		}
+	}
+	histogram("surface_render_duration_seconds", "Time to handle a render request.", durationBuckets,
+		func(f *functionMetrics) []uint64 { return f.buckets },
+		func(f *functionMetrics) float64 { return f.seconds })
+	histogram("surface_response_size_bytes", "Response body size of a render request.", sizeBuckets,
+		func(f *functionMetrics) []uint64 { return f.sizeBuckets },
+		func(f *functionMetrics) float64 { return float64(f.bytes) })
+
+	fmt.Fprintf(w, "# HELP surface_cache_hits_total Renders served from the cache.\n# TYPE surface_cache_hits_total counter\nsurface_cache_hits_total %d\n", m.cacheHits)
+	fmt.Fprintf(w, "# HELP surface_cache_misses_total Cacheable renders not found in the cache.\n# TYPE surface_cache_misses_total counter\nsurface_cache_misses_total %d\n", m.cacheMisses)
+	fmt.Fprintf(w, "# HELP surface_cache_bytes Size of the rendered responses in the cache.\n# TYPE surface_cache_bytes gauge\nsurface_cache_bytes %d\n", renderer.CacheSize())
+	fmt.Fprintf(w, "# HELP surface_requests_in_flight Render requests being handled.\n# TYPE surface_requests_in_flight gauge\nsurface_requests_in_flight %d\n", m.inFlight.Load())
+}
+
+// responseRecorder remembers the status and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush sends what has been written so far to the client.
+func (rec *responseRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection, as the WebSocket handshake of /ws
+// does, which switches protocols.
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rec.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
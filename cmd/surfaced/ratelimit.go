@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mxschardt/surface"
+)
+
+// limiter is a set of token buckets, one per key, each holding up to burst
+// tokens and refilled at rate tokens a second. Every request takes a token.
+type limiter struct {
+	rate, burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(max(burst, 1)), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of key, or reports how long until
+// one is available.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops, about once a minute, the buckets that have refilled: they
+// are the same as new ones.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit rejects requests to h beyond the rates set by the flags, per
+// client address and over all clients, with 429 Too Many Requests and a
+// Retry-After header. A request rejected for its client takes no token
+// from the global bucket.
+func rateLimit(h http.Handler) http.Handler {
+	var perIP, global *limiter
+	if *rateFlag > 0 {
+		perIP = newLimiter(*rateFlag, *burstFlag)
+	}
+	if *globalRateFlag > 0 {
+		global = newLimiter(*globalRateFlag, *globalBurstFlag)
+	}
+	return limitRates(h, perIP, global, time.Now)
+}
+
+// limitRates is rateLimit with the buckets perIP and global, either nil for
+// no limit, and the clock now.
+func limitRates(h http.Handler, perIP, global *limiter, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := now()
+		if perIP != nil {
+			if ok, wait := perIP.allow(clientIP(r), t); !ok {
+				tooManyRequests(w, wait, fmt.Errorf("too many requests from %s, at most %g a second", clientIP(r), perIP.rate))
+				return
+			}
+		}
+		if global != nil {
+			if ok, wait := global.allow("", t); !ok {
+				tooManyRequests(w, wait, fmt.Errorf("too many requests, at most %g a second", global.rate))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	surface.WriteError(w, err, http.StatusTooManyRequests)
+}
+
+// clientIP returns the address of the client of r, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
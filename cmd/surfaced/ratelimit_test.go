@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// clock is a settable time for limiters.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, 3)
+	t0 := time.Unix(1e9, 0)
+	for k := range 3 {
+		if ok, _ := l.allow("a", t0); !ok {
+			t.Fatalf("request %d of the burst was refused", k)
+		}
+	}
+	if ok, wait := l.allow("a", t0); ok || wait != 500*time.Millisecond {
+		t.Errorf("request past the burst: %v, wait %v; want refused and 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("b", t0); !ok {
+		t.Error("another key shares the bucket of the first")
+	}
+	if ok, wait := l.allow("a", t0.Add(250*time.Millisecond)); ok || wait != 250*time.Millisecond {
+		t.Errorf("half a token later: %v, wait %v; want refused and 250ms", ok, wait)
+	}
+	if ok, _ := l.allow("a", t0.Add(500*time.Millisecond)); !ok {
+		t.Error("refilled token was refused")
+	}
+	// Refilling stops at the burst.
+	t1 := t0.Add(time.Hour)
+	for k := range 3 {
+		if ok, _ := l.allow("b", t1); !ok {
+			t.Fatalf("request %d of the refilled burst was refused", k)
+		}
+	}
+	if ok, _ := l.allow("b", t1); ok {
+		t.Error("the bucket refilled past the burst")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter(1, 10) // a bucket refills in 10s
+	t0 := time.Unix(1e9, 0)
+	l.allow("stale", t0) // and sweeps, the first time
+	l.allow("fresh", t0.Add(55*time.Second))
+	l.allow("other", t0.Add(61*time.Second)) // sweeps again, a minute on
+	if _, ok := l.buckets["stale"]; ok {
+		t.Error("the refilled bucket was kept")
+	}
+	if _, ok := l.buckets["fresh"]; !ok {
+		t.Error("the bucket still refilling was dropped")
+	}
+}
+
+func TestLimitRates(t *testing.T) {
+	c := &clock{t: time.Unix(1e9, 0)}
+	h := limitRates(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), newLimiter(1, 2), newLimiter(100, 3), c.now)
+	serve := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for _, addr := range []string{"192.0.2.1:1000", "192.0.2.1:1001"} {
+		if w := serve(addr); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", addr, w.Code)
+		}
+	}
+	w := serve("192.0.2.1:1002")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("past the burst of the client: status %d, Retry-After %q; want 429 and 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	// The refused request took no token from the global bucket, which has
+	// one left.
+	if w := serve("192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("another client: status %d, want 200", w.Code)
+	}
+	if w := serve("192.0.2.3:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("past the global burst: status %d, want 429", w.Code)
+	}
+	c.t = c.t.Add(time.Second)
+	if w := serve("192.0.2.1:1003"); w.Code != http.StatusOK {
+		t.Errorf("a second later: status %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mxschardt/surface"
+)
+
+const renderUsage = `usage: surfaced render [-o file] [-i file] [-param value ...]
+
+Render writes a surface to the file given by -o, or to standard output.
+Every query parameter of the HTTP server is accepted as a flag, e.g.
+
+	surfaced render -function eggbox -o out.svg -width 1200 -height 800
+
+A flag without a value, such as -shading, is set to true. With
+-function heightmap the heights are read from the file given by -i, or from
+standard input, as CSV or, if the file name ends in .json, as JSON.
+`
+
+// renderCommand implements "surfaced render", rendering a single surface
+// offline with the same options as the HTTP handler.
+func renderCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	q, output, input, err := renderArgs(args)
+	if err != nil {
+		return err
+	}
+	opts, function, err := surface.ParseQuery(q)
+	if err != nil {
+		return err
+	}
+	if function == "heightmap" {
+		in := stdin
+		if input != "" {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		if opts.Projector, err = surface.ReadHeightmap(in, filepath.Ext(input) == ".json"); err != nil {
+			return err
+		}
+	}
+
+	out := stdout
+	var f *os.File
+	if output != "" && output != "-" {
+		if f, err = os.Create(output); err != nil {
+			return err
+		}
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	err = surface.Render(bw, opts)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// renderArgs splits the arguments of the render command into query
+// parameters and the output and input file names.
+func renderArgs(args []string) (q url.Values, output, input string, err error) {
+	q = make(url.Values)
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		name, ok := strings.CutPrefix(arg, "-")
+		if !ok || name == "" {
+			return nil, "", "", fmt.Errorf("unexpected argument %q\n\n%s", arg, renderUsage)
+		}
+		name = strings.TrimPrefix(name, "-")
+		if name == "h" || name == "help" {
+			return nil, "", "", errors.New(renderUsage)
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		if !hasValue {
+			// The next argument is the value unless it is another flag;
+			// negative numbers such as -rotate -30 are values.
+			value = "true"
+			if len(args) > 0 && (!strings.HasPrefix(args[0], "-") || isNumber(args[0])) {
+				value, args = args[0], args[1:]
+			}
+		}
+		switch name {
+		case "o":
+			output = value
+		case "i":
+			input = value
+		default:
+			q.Add(name, value)
+		}
+	}
+	return q, output, input, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
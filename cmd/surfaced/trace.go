@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// span is a timed operation of a trace, exported to the OTLP/HTTP
+// collector at -otlp.
+type span struct {
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // zero for a root span
+	name    string
+	server  bool // the span of a request, rather than internal to it
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     string
+}
+
+// spanContext is the request context key of the span of a request.
+type spanContext struct{}
+
+// spans queues finished spans for export; nil while tracing is off.
+var spans chan *span
+
+const (
+	spanQueue = 4096 // spans queued for export before more are dropped
+	spanBatch = 512  // most spans per export request
+)
+
+// startSpan starts a root span named name.
+func startSpan(name string) *span {
+	s := &span{name: name, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.id[:])
+	return s
+}
+
+// child starts a span named name within s, or returns nil if s is nil.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{traceID: s.traceID, parent: s.id, name: name, start: time.Now()}
+	rand.Read(c.id[:])
+	return c
+}
+
+// finish ends s and queues it for export, dropping it if the queue is
+// full. It does nothing if s is nil.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case spans <- s:
+	default:
+	}
+}
+
+// traceRequests records a server span for each request to h, continuing
+// the trace of a W3C traceparent header if there is one, and puts it in
+// the request context for handler to add the spans of the render to.
+// Without -otlp it is h.
+func traceRequests(h http.Handler) http.Handler {
+	if *otlpFlag == "" {
+		return h
+	}
+	spans = make(chan *span, spanQueue)
+	go exportSpans(*otlpFlag)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := startSpan(r.Method + " " + r.URL.Path)
+		s.server = true
+		if traceID, parent, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.traceID, s.parent = traceID, parent
+		}
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanContext{}, s)))
+		s.attrs = map[string]string{
+			"http.request.method":       r.Method,
+			"url.path":                  r.URL.Path,
+			"http.response.status_code": strconv.Itoa(rec.status),
+		}
+		if rec.status >= http.StatusInternalServerError {
+			s.err = http.StatusText(rec.status)
+		}
+		s.finish()
+	})
+}
+
+// requestSpan returns the span of r, or nil if it is not traced.
+func requestSpan(r *http.Request) *span {
+	s, _ := r.Context().Value(spanContext{}).(*span)
+	return s
+}
+
+// parseTraceparent parses a traceparent header of version 00.
+func parseTraceparent(h string) (traceID [16]byte, parent [8]byte, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parent, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parent, false
+	}
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil {
+		return traceID, parent, false
+	}
+	return traceID, parent, traceID != [16]byte{} && parent != [8]byte{}
+}
+
+// exportSpans posts the queued spans to the collector at url in batches,
+// at least every few seconds while there are any.
+func exportSpans(url string) {
+	var batch []*span
+	tick := time.NewTicker(5 * time.Second)
+	for {
+		select {
+		case s := <-spans:
+			if batch = append(batch, s); len(batch) < spanBatch {
+				continue
+			}
+		case <-tick.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := postSpans(url, batch); err != nil {
+			logger.Warn("exporting spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+var exportClient = &http.Client{Timeout: 10 * time.Second}
+
+// postSpans sends spans to the collector at url in the JSON encoding of
+// OTLP/HTTP.
+func postSpans(url string, spans []*span) error {
+	type value struct {
+		StringValue string `json:"stringValue"`
+	}
+	type attribute struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	type status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID      string      `json:"traceId"`
+		SpanID       string      `json:"spanId"`
+		ParentSpanID string      `json:"parentSpanId,omitempty"`
+		Name         string      `json:"name"`
+		Kind         int         `json:"kind"`
+		Start        string      `json:"startTimeUnixNano"`
+		End          string      `json:"endTimeUnixNano"`
+		Attributes   []attribute `json:"attributes,omitempty"`
+		Status       status      `json:"status"`
+	}
+	out := make([]otlpSpan, len(spans))
+	for k, s := range spans {
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.id[:]),
+			Name:    s.name,
+			Kind:    1, // internal
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.server {
+			o.Kind = 2
+		}
+		for key, v := range s.attrs {
+			o.Attributes = append(o.Attributes, attribute{key, value{v}})
+		}
+		if s.err != "" {
+			o.Status = status{Code: 2, Message: s.err}
+		}
+		out[k] = o
+	}
+	doc := map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": []attribute{{"service.name", value{"surfaced"}}}},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]string{"name": "github.com/mxschardt/surface/cmd/surfaced"},
+			"spans": out,
+		}},
+	}}}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	resp, err := exportClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector replied %s", resp.Status)
+	}
+	return nil
+}
+
+// renderTrace returns a surface.Options.Trace that records the stages of
+// a render as children of parent, or nil if parent is nil.
+func renderTrace(parent *span) func(stage string) func() {
+	if parent == nil {
+		return nil
+	}
+	return func(stage string) func() {
+		return parent.child("render." + stage).finish
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+
+	"github.com/mxschardt/surface"
+)
+
+// uiPage is the interactive page served at /ui, which renders from the
+// controls it has through the query parameters.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+func uiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		surface.WriteError(w, fmt.Errorf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' blob:; style-src 'unsafe-inline'; script-src 'unsafe-inline'")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(uiPage)
+}
@@ -0,0 +1,72 @@
+//go:build js && wasm
+
+// Command surfacewasm exposes the renderer to JavaScript when built for
+// WebAssembly:
+//
+//	GOOS=js GOARCH=wasm go build -o surface.wasm ./cmd/surfacewasm
+//
+// Once run with the wasm_exec.js of the Go distribution, it defines a
+// global surfaceRender(query) taking the query parameters of a render, as
+// a string such as "function=eggbox&cells=50" or a URLSearchParams, and
+// returning {contentType, data}, data being a string for SVG, JSON and
+// OBJ and a Uint8Array for other formats, or {error} listing the invalid
+// parameters. function=heightmap takes the heights as a second argument,
+// an array of rows.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"syscall/js"
+
+	"github.com/mxschardt/surface"
+)
+
+func main() {
+	js.Global().Set("surfaceRender", js.FuncOf(render))
+	select {} // keep the exports alive
+}
+
+func render(this js.Value, args []js.Value) any {
+	if len(args) == 0 {
+		return fail(errors.New("surfaceRender: missing query"))
+	}
+	q, err := url.ParseQuery(js.Global().Get("String").Invoke(args[0]).String())
+	if err != nil {
+		return fail(err)
+	}
+	opts, function, err := surface.ParseQuery(q)
+	if err != nil {
+		return fail(err)
+	}
+	if function == "heightmap" {
+		if len(args) < 2 {
+			return fail(errors.New("surfaceRender: 'function'=heightmap needs the heights"))
+		}
+		json := js.Global().Get("JSON").Call("stringify", args[1]).String()
+		if opts.Projector, err = surface.ReadHeightmap(bytes.NewReader([]byte(json)), true); err != nil {
+			return fail(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := surface.Render(&buf, opts); err != nil {
+		return fail(err)
+	}
+	out := map[string]any{"contentType": surface.ContentType(opts.Format)}
+	switch opts.Format {
+	case "", "svg", "json", "obj":
+		out["data"] = buf.String()
+	default:
+		data := js.Global().Get("Uint8Array").New(buf.Len())
+		js.CopyBytesToJS(data, buf.Bytes())
+		out["data"] = data
+	}
+	return out
+}
+
+// fail returns the result of a render that failed with err. A Go panic
+// would end the program rather than throw, so errors are returned.
+func fail(err error) any {
+	return map[string]any{"error": err.Error()}
+}
@@ -0,0 +1,48 @@
+package surface
+
+import (
+	"image/color"
+	"sort"
+)
+
+// colormaps are perceptual gradients from the lowest to the highest z,
+// sampled evenly from the matplotlib colormaps of the same names (and, for
+// turbo, Google's polynomial fit of it) for linear interpolation by zcolor.
+var colormaps = map[string][]color.RGBA{
+	"coolwarm": hexColors(0x3b4cc0, 0x5a78e4, 0x7b9ff9, 0x9ebeff, 0xc0d4f5, 0xdddcdc,
+		0xf2cbb7, 0xf7ac8e, 0xee8468, 0xd65244, 0xb40426),
+	"viridis": hexColors(0x440154, 0x482475, 0x414487, 0x355f8d, 0x2a788e, 0x21918c,
+		0x22a884, 0x44bf70, 0x7ad151, 0xbddf26, 0xfde725),
+	"plasma": hexColors(0x0d0887, 0x41049d, 0x6a00a8, 0x8f0da4, 0xb12a90, 0xcc4778,
+		0xe16462, 0xf2844b, 0xfca636, 0xfcce25, 0xf0f921),
+	"magma": hexColors(0x000004, 0x140e36, 0x3b0f70, 0x641a80, 0x8c2981, 0xb73779,
+		0xde4968, 0xf7705c, 0xfe9f6d, 0xfecf92, 0xfcfdbf),
+	"turbo": hexColors(0x23171b, 0x493eaf, 0x446aee, 0x3295f7, 0x26bde1, 0x29ddbb,
+		0x40f392, 0x66fd6d, 0x96fa50, 0xc6eb3b, 0xeed02d, 0xffab24, 0xff801d, 0xee5415,
+		0xc92d0c, 0xa11202, 0x900d00),
+}
+
+func hexColors(rgbs ...uint32) []color.RGBA {
+	cs := make([]color.RGBA, len(rgbs))
+	for k, rgb := range rgbs {
+		cs[k] = color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}
+	}
+	return cs
+}
+
+// Colormap returns the stops of the built-in colormap called name, for
+// Options.Stops.
+func Colormap(name string) ([]color.RGBA, bool) {
+	stops, ok := colormaps[name]
+	return append([]color.RGBA(nil), stops...), ok
+}
+
+// Colormaps returns the sorted names of the built-in colormaps.
+func Colormaps() []string {
+	names := make([]string, 0, len(colormaps))
+	for name := range colormaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,190 @@
+package surface
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sum is the surface of the heights of its projectors added together, over
+// the points of the first.
+type Sum []Projector
+
+func (s Sum) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y, z := s[0].Corner(g, i, j)
+	for _, p := range s[1:] {
+		_, _, pz := p.Corner(g, i, j)
+		z += pz
+	}
+	return x, y, z
+}
+
+// Product is the surface of the heights of its projectors multiplied
+// together, over the points of the first.
+type Product []Projector
+
+func (s Product) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y, z := s[0].Corner(g, i, j)
+	for _, p := range s[1:] {
+		_, _, pz := p.Corner(g, i, j)
+		z *= pz
+	}
+	return x, y, z
+}
+
+// Scale is the surface of P with its heights multiplied by Factor.
+type Scale struct {
+	Factor float64
+	P      Projector
+}
+
+func (s Scale) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y, z := s.P.Corner(g, i, j)
+	return x, y, s.Factor * z
+}
+
+// isComposite reports whether the function name s combines functions
+// rather than naming one.
+func isComposite(s string) bool {
+	return strings.ContainsAny(s, "+-*() ") || s != "" && (s[0] >= '0' && s[0] <= '9' || s[0] == '.')
+}
+
+// parseComposite returns the combination of the height fields named in s,
+// found by lookup, such as 0.5*moguls-saddle, and their names. s is a sum or
+// difference of terms, each the product of functions, numbers and
+// parenthesized combinations. As + decodes to a space in a query, a space
+// between two terms adds them.
+func parseComposite(s string, lookup func(name string) (Projector, bool)) (Projector, []string, error) {
+	if len(s) > maxExprLen {
+		return nil, nil, fmt.Errorf("'function' is %d bytes long, more than %d", len(s), maxExprLen)
+	}
+	p := &compositeParser{exprParser: exprParser{src: s}, lookup: lookup}
+	p.next()
+	c, err := p.sum()
+	if err == nil && p.tok != "" {
+		err = p.errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, p.names, nil
+}
+
+// compositeParser is a recursive descent parser over the grammar
+//
+//	sum    = term { ("+" | "-" | " ") term }
+//	term   = factor { "*" factor }
+//	factor = "-" factor | number | name | "(" sum ")"
+//
+// with the tokens of exprParser.
+type compositeParser struct {
+	exprParser
+	lookup func(name string) (Projector, bool)
+	names  []string // of the functions, in order
+}
+
+func (p *compositeParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("'function' %q: %s at offset %d", p.src, fmt.Sprintf(format, a...), p.start)
+}
+
+// spaced reports whether the current token is the start of a term after a
+// space, which stands for +.
+func (p *compositeParser) spaced() bool {
+	switch p.tok {
+	case "", "+", "-", "*", ")":
+		return false
+	}
+	return p.start > 0 && p.src[p.start-1] == ' '
+}
+
+func (p *compositeParser) sum() (Projector, error) {
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	terms := Sum{t}
+	for p.tok == "+" || p.tok == "-" || p.spaced() {
+		op := p.tok
+		if op == "+" || op == "-" {
+			p.next()
+		}
+		if t, err = p.term(); err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			t = Scale{-1, t}
+		}
+		terms = append(terms, t)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *compositeParser) term() (Projector, error) {
+	factor := 1.0
+	var factors Product
+	for {
+		switch {
+		case p.tok == "-":
+			factor = -factor
+			p.next()
+			continue
+		case p.tok == "(":
+			if p.depth++; p.depth > maxExprDepth {
+				return nil, p.errorf("too deeply nested")
+			}
+			p.next()
+			c, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			if p.tok != ")" {
+				return nil, p.errorf("want )")
+			}
+			p.depth--
+			p.next()
+			factors = append(factors, c)
+		case p.tok != "" && (p.tok[0] >= '0' && p.tok[0] <= '9' || p.tok[0] == '.'):
+			v, err := strconv.ParseFloat(p.tok, 64)
+			if err != nil {
+				return nil, p.errorf("bad number %q", p.tok)
+			}
+			factor *= v
+			p.next()
+		case p.tok != "" && isLetter(p.tok[0]):
+			f, ok := p.lookup(p.tok)
+			if !ok {
+				return nil, p.errorf("unknown function %q", p.tok)
+			}
+			if _, ok := f.(ParametricProjector); ok {
+				return nil, p.errorf("cannot combine the parametric surface %s", p.tok)
+			}
+			p.names = append(p.names, p.tok)
+			factors = append(factors, f)
+			p.next()
+		case p.tok == "":
+			return nil, p.errorf("unexpected end")
+		default:
+			return nil, p.errorf("unexpected %q", p.tok)
+		}
+		if p.tok != "*" {
+			break
+		}
+		p.next()
+	}
+	if len(factors) == 0 {
+		return nil, p.errorf("term without a function")
+	}
+	var t Projector = factors
+	if len(factors) == 1 {
+		t = factors[0]
+	}
+	if factor != 1 {
+		t = Scale{factor, t}
+	}
+	return t, nil
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
@@ -0,0 +1,115 @@
+package surface
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+)
+
+const maxContourLevels = 1000 // most contour lines a single render may draw
+
+// contourLevels returns the levels of the contour lines of opts within the
+// heights of b: the multiples of opts.Contours, or else opts.ContourLevels
+// evenly spaced levels strictly between zmin and zmax.
+func contourLevels(b bounds, opts Options) ([]float64, error) {
+	if b.zmax < b.zmin {
+		return nil, nil
+	}
+	interval := opts.Contours
+	if interval <= 0 {
+		n := opts.ContourLevels
+		if n <= 0 {
+			return nil, nil
+		}
+		if n > maxContourLevels {
+			return nil, fmt.Errorf("surface: %d ContourLevels, more than %d", n, maxContourLevels)
+		}
+		levels := make([]float64, n)
+		for k := range levels {
+			levels[k] = b.zmin + float64(k+1)/float64(n+1)*(b.zmax-b.zmin)
+		}
+		return levels, nil
+	}
+	first, last := math.Ceil(b.zmin/interval), math.Floor(b.zmax/interval)
+	if n := last - first + 1; n > maxContourLevels {
+		return nil, fmt.Errorf("surface: Contours interval %g gives %g levels, more than %d", interval, n, maxContourLevels)
+	}
+	var levels []float64
+	for k := first; k <= last; k++ {
+		levels = append(levels, k*interval)
+	}
+	return levels, nil
+}
+
+// contours writes the contour lines of m at each level over the surface,
+// one SVG path per level. The lines are found by marching squares: a level
+// crosses each cell edge whose corner heights lie on either side of it.
+func contours(ctx context.Context, w io.Writer, m *mesh, levels []float64, opts Options) error {
+	c := opts.ContourColor
+	for _, level := range levels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var d []byte
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				if p.valid {
+					d = p.appendContour(d, level, opts.Precision)
+				}
+			}
+		}
+		if len(d) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "<path d='%s' fill='none' stroke='#%02x%02x%02x' stroke-width='%g'><title>z=%g</title></path>\n",
+			d, c.R, c.G, c.B, opts.ContourWidth, level)
+	}
+	return nil
+}
+
+// appendContour appends to d the path segments where level crosses p.
+func (p polygon) appendContour(d []byte, level float64, precision int) []byte {
+	for _, s := range p.contourSegments(level) {
+		d = append(d, 'M')
+		d = appendCoord(d, s[0], precision)
+		d = append(d, ' ')
+		d = appendCoord(d, s[1], precision)
+		d = append(d, 'L')
+		d = appendCoord(d, s[2], precision)
+		d = append(d, ' ')
+		d = appendCoord(d, s[3], precision)
+	}
+	return d
+}
+
+// contourSegments returns the projected segments, as x0, y0, x1, y1, where
+// level crosses p.
+func (p polygon) contourSegments(level float64) [][4]float64 {
+	var crossings [4][2]float64
+	n := 0
+	for k := 0; k < 4; k++ {
+		l := (k + 1) % 4
+		z0, z1 := p.corners[k], p.corners[l]
+		if (z0 < level) == (z1 < level) {
+			continue
+		}
+		// The projection is linear, so interpolating the projected corners
+		// gives the projection of the crossing point on the cell edge.
+		t := (level - z0) / (z1 - z0)
+		crossings[n] = [2]float64{
+			p.points[2*k] + t*(p.points[2*l]-p.points[2*k]),
+			p.points[2*k+1] + t*(p.points[2*l+1]-p.points[2*k+1]),
+		}
+		n++
+	}
+	// A cell has two crossings, or four at a saddle point, where they are
+	// paired with their neighbours along the cell boundary.
+	var segments [][4]float64
+	for k := 0; k+1 < n; k += 2 {
+		segments = append(segments, [4]float64{
+			crossings[k][0], crossings[k][1], crossings[k+1][0], crossings[k+1][1],
+		})
+	}
+	return segments
+}
@@ -0,0 +1,90 @@
+package surface
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// slope is a Projector of the plane z = x + 0.5.
+type slope struct{}
+
+func (slope) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	return x, y, x + 0.5
+}
+
+func TestContourSegments(t *testing.T) {
+	// Over z in -14.5..15.5, the levels are -10, 0 and 10, each crossing a
+	// single column of cells, once in each of its 10 cells.
+	opts := Options{Projector: slope{}, Cells: 10, Contours: 10}.withDefaults()
+	m, err := sample(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, err := contourLevels(m.bounds, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 3 || levels[0] != -10 || levels[1] != 0 || levels[2] != 10 {
+		t.Fatalf("levels %v, want [-10 0 10]", levels)
+	}
+	for _, level := range levels {
+		n := 0
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				n += len(p.contourSegments(level))
+			}
+		}
+		if n != 10 {
+			t.Errorf("level %g: %d segments, want 10", level, n)
+		}
+	}
+}
+
+func TestContourQuery(t *testing.T) {
+	// contours is the interval between levels, as it always was, and
+	// contourlevels their number.
+	for query, want := range map[string][]float64{
+		"contours=10":     {-10, 0, 10},
+		"contourlevels=2": {-4.5, 5.5},
+	} {
+		opts, _, err := ParseQuery(mustQuery(t, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Projector, opts.Cells = slope{}, 10
+		opts = opts.withDefaults()
+		m, err := sample(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		levels, err := contourLevels(m.bounds, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(levels) != len(want) {
+			t.Errorf("%s: levels %v, want %v", query, levels, want)
+			continue
+		}
+		for k := range want {
+			if math.Abs(levels[k]-want[k]) > 1e-9 {
+				t.Errorf("%s: levels %v, want %v", query, levels, want)
+				break
+			}
+		}
+	}
+	h := NewHandler(HandlerConfig{})
+	for target, want := range map[string]int{
+		"/?cells=10&contours=0.5":                http.StatusOK,
+		"/?cells=10&contourlevels=10":            http.StatusOK,
+		"/?cells=10&contours=-1":                 http.StatusBadRequest,
+		"/?cells=10&contourlevels=1001":          http.StatusBadRequest,
+		"/?cells=10&contours=1&contourlevels=10": http.StatusBadRequest,
+	} {
+		if got := status(h, target); got != want {
+			t.Errorf("%s: status %d, want %d", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package surface
+
+import "image"
+
+// cull drops from m.order the cells that face away from the viewer and
+// those that later cells hide entirely. Visibility is decided at the
+// resolution of the canvas: the cells are drawn back to front into a
+// buffer of cell numbers, and a cell that covered at least one pixel
+// center but owns none of them at the end is hidden. Cells too small to
+// cover any pixel center are kept.
+func (m *mesh) cull(opts Options) {
+	pr := opts.projection()
+	// A flat cell seen from above fixes the winding of the front faces.
+	ax, ay := pr.project(1, 0, 0)
+	bx, by := pr.project(0, 0, 0)
+	cx, cy := pr.project(0, 1, 0)
+	front := area([8]float64{ax, ay, bx, by, cx, cy, ax + cx - bx, ay + cy - by}) > 0
+
+	tr := newTransform(m.bounds, opts)
+	r := image.Rect(0, 0, opts.Width, opts.Height)
+	owner := make([]int32, opts.Width*opts.Height)
+	for k := range owner {
+		owner[k] = -1
+	}
+	covered := make([]bool, len(m.order))
+	kept := m.order[:0]
+	for _, ij := range m.order {
+		p := m.polygons[ij[0]][ij[1]]
+		if a := area(p.points); a != 0 && (a > 0) != front {
+			continue // facing away
+		}
+		n := int32(len(kept))
+		kept = append(kept, ij)
+		pts := tr.apply(p.points)
+		scanPolygon(pts[:], r, func(x0, x1, y int) {
+			covered[n] = true
+			row := owner[y*opts.Width : (y+1)*opts.Width]
+			for x := x0; x < x1; x++ {
+				row[x] = n
+			}
+		})
+	}
+
+	visible := make([]bool, len(kept))
+	for _, n := range owner {
+		if n >= 0 {
+			visible[n] = true
+		}
+	}
+	order := kept[:0]
+	for n, ij := range kept {
+		if visible[n] || !covered[n] {
+			order = append(order, ij)
+		}
+	}
+	if opts.Stats != nil {
+		opts.Stats.Culled += len(m.order) - len(order)
+	}
+	m.order = order
+}
+
+// area returns the signed area of the quad with corners pts; its sign
+// tells the winding of the corners on the canvas.
+func area(pts [8]float64) float64 {
+	sum := 0.0
+	for k := 0; k < 8; k += 2 {
+		l := (k + 2) % 8
+		sum += pts[k]*pts[l+1] - pts[l]*pts[k+1]
+	}
+	return sum / 2
+}
@@ -0,0 +1,100 @@
+package surface
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxFilename is the longest file name a request may ask for.
+const maxFilename = 200
+
+// contentDisposition returns the Content-Disposition header of the
+// response to the query q, for the render of function in format, or "" if
+// q asks for none. With download set the rendering is an attachment, and
+// its file name is filename, in which each {name} stands for the value of
+// the parameter name, or else its default, with the extension of format.
+func contentDisposition(q url.Values, function, format string) (string, error) {
+	download := false
+	if s := q.Get("download"); s != "" {
+		var err error
+		if download, err = strconv.ParseBool(s); err != nil {
+			return "", ParamErrors{{Param: "download", Message: fmt.Sprintf("cannot parse 'download' %q to a boolean", s)}}
+		}
+	}
+	tmpl := q.Get("filename")
+	if !download && tmpl == "" {
+		return "", nil
+	}
+	if tmpl == "" {
+		tmpl = "{function}"
+	}
+	var name strings.Builder
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			name.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", ParamErrors{{Param: "filename", Message: fmt.Sprintf("unterminated {} in 'filename' %q", tmpl)}}
+		}
+		name.WriteString(rest[:open])
+		param := rest[open+1 : open+end]
+		v, ok := templateValue(q, param, function)
+		if !ok {
+			return "", ParamErrors{{Param: "filename", Message: fmt.Sprintf("unknown parameter {%s} in 'filename' %q", param, tmpl)}}
+		}
+		name.WriteString(v)
+		rest = rest[open+end+1:]
+	}
+	file := sanitizeFilename(name.String())
+	if ext := extensions[ContentType(format)]; !strings.HasSuffix(file, ext) {
+		file += ext
+	}
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": file}), nil
+}
+
+// templateValue returns the value of the parameter name for a filename
+// template: that of q, or else its default.
+func templateValue(q url.Values, name, function string) (string, bool) {
+	if name == "function" {
+		return function, true
+	}
+	for _, p := range parameters {
+		if p.Name == name {
+			if q.Has(name) {
+				return q.Get(name), true
+			}
+			return p.Default, true
+		}
+	}
+	return "", false
+}
+
+// sanitizeFilename replaces the characters of name other than letters,
+// digits, dots, dashes and underscores with underscores, and keeps it to
+// maxFilename bytes.
+func sanitizeFilename(name string) string {
+	b := []byte(name)
+	for k, c := range b {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_') {
+			b[k] = '_'
+		}
+	}
+	name = strings.TrimLeft(string(b), ".")
+	if len(name) > maxFilename {
+		name = name[:maxFilename]
+	}
+	if name == "" {
+		name = "surface"
+	}
+	return name
+}
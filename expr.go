@@ -0,0 +1,293 @@
+package surface
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxExprLen = 1000 // longest expression NewExprProjector accepts
+
+// ExprProjector computes z from an arithmetic expression in x and y.
+type ExprProjector struct {
+	src string
+	f   exprFunc
+}
+
+type exprFunc func(x, y float64) float64
+
+// NewExprProjector compiles the expression src. It may use the variables x,
+// y and r (the distance from the origin), the constants pi and e, the
+// operators + - * / % ^ (power) with parentheses, and the functions
+//
+//	abs acos asin atan atan2 ceil cos cosh exp floor hypot log log10 log2
+//	max min mod pow sin sinh sqrt tan tanh
+func NewExprProjector(src string) (ExprProjector, error) {
+	if len(src) > maxExprLen {
+		return ExprProjector{}, fmt.Errorf("expression is %d bytes long, more than %d", len(src), maxExprLen)
+	}
+	p := &exprParser{src: src}
+	p.next()
+	f, err := p.expr()
+	if err == nil && p.tok != "" {
+		err = p.errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return ExprProjector{}, err
+	}
+	return ExprProjector{src: src, f: f}, nil
+}
+
+func (e ExprProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	return x, y, e.f(x, y)
+}
+
+// String returns the source of the expression.
+func (e ExprProjector) String() string {
+	return e.src
+}
+
+var exprFuncs1 = map[string]func(float64) float64{
+	"abs": math.Abs, "acos": math.Acos, "asin": math.Asin, "atan": math.Atan,
+	"ceil": math.Ceil, "cos": math.Cos, "cosh": math.Cosh, "exp": math.Exp,
+	"floor": math.Floor, "log": math.Log, "log10": math.Log10, "log2": math.Log2,
+	"sin": math.Sin, "sinh": math.Sinh, "sqrt": math.Sqrt, "tan": math.Tan,
+	"tanh": math.Tanh,
+}
+
+var exprFuncs2 = map[string]func(float64, float64) float64{
+	"atan2": math.Atan2, "hypot": math.Hypot, "max": math.Max, "min": math.Min,
+	"mod": math.Mod, "pow": math.Pow,
+}
+
+// exprParser is a recursive descent parser over the grammar
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%") unary }
+//	unary   = ("-" | "+") unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | name | name "(" expr { "," expr } ")" | "(" expr ")"
+type exprParser struct {
+	src   string
+	pos   int    // offset of the byte after tok
+	tok   string // current token; "" at the end of the input
+	start int    // offset of tok
+	depth int    // nesting of parentheses and unary operators
+}
+
+const maxExprDepth = 100
+
+func (p *exprParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("expression %q: %s at offset %d", p.src, fmt.Sprintf(format, a...), p.start)
+}
+
+// next advances to the next token.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	p.start = p.pos
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		end := p.pos
+		for end < len(p.src) && (unicode.IsDigit(rune(p.src[end])) || p.src[end] == '.') {
+			end++
+		}
+		// Exponent, as in 1e-3.
+		if end < len(p.src) && (p.src[end] == 'e' || p.src[end] == 'E') {
+			k := end + 1
+			if k < len(p.src) && (p.src[k] == '+' || p.src[k] == '-') {
+				k++
+			}
+			if k < len(p.src) && unicode.IsDigit(rune(p.src[k])) {
+				for k < len(p.src) && unicode.IsDigit(rune(p.src[k])) {
+					k++
+				}
+				end = k
+			}
+		}
+		p.pos = end
+	case unicode.IsLetter(c):
+		end := p.pos
+		for end < len(p.src) && (unicode.IsLetter(rune(p.src[end])) || unicode.IsDigit(rune(p.src[end]))) {
+			end++
+		}
+		p.pos = end
+	default:
+		p.pos++
+	}
+	p.tok = p.src[p.start:p.pos]
+}
+
+func (p *exprParser) expr() (exprFunc, error) {
+	f, err := p.term()
+	for err == nil && (p.tok == "+" || p.tok == "-") {
+		op := p.tok
+		p.next()
+		var g exprFunc
+		if g, err = p.term(); err == nil {
+			f = binaryOp(op, f, g)
+		}
+	}
+	return f, err
+}
+
+func (p *exprParser) term() (exprFunc, error) {
+	f, err := p.unary()
+	for err == nil && (p.tok == "*" || p.tok == "/" || p.tok == "%") {
+		op := p.tok
+		p.next()
+		var g exprFunc
+		if g, err = p.unary(); err == nil {
+			f = binaryOp(op, f, g)
+		}
+	}
+	return f, err
+}
+
+func (p *exprParser) unary() (exprFunc, error) {
+	if p.tok != "-" && p.tok != "+" {
+		return p.power()
+	}
+	if p.depth++; p.depth > maxExprDepth {
+		return nil, p.errorf("expression nested too deeply")
+	}
+	defer func() { p.depth-- }()
+	op := p.tok
+	p.next()
+	f, err := p.unary()
+	if err != nil || op == "+" {
+		return f, err
+	}
+	return func(x, y float64) float64 { return -f(x, y) }, nil
+}
+
+func (p *exprParser) power() (exprFunc, error) {
+	f, err := p.primary()
+	if err != nil || p.tok != "^" {
+		return f, err
+	}
+	p.next()
+	g, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return binaryOp("^", f, g), nil
+}
+
+func (p *exprParser) primary() (exprFunc, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end of expression")
+	case tok == "(":
+		if p.depth++; p.depth > maxExprDepth {
+			return nil, p.errorf("expression nested too deeply")
+		}
+		defer func() { p.depth-- }()
+		p.next()
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("missing ')'")
+		}
+		p.next()
+		return f, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok)
+		}
+		p.next()
+		return func(x, y float64) float64 { return v }, nil
+	case unicode.IsLetter(rune(tok[0])):
+		p.next()
+		if p.tok == "(" {
+			return p.call(strings.ToLower(tok))
+		}
+		switch strings.ToLower(tok) {
+		case "x":
+			return func(x, y float64) float64 { return x }, nil
+		case "y":
+			return func(x, y float64) float64 { return y }, nil
+		case "r":
+			return math.Hypot, nil
+		case "pi":
+			return func(x, y float64) float64 { return math.Pi }, nil
+		case "e":
+			return func(x, y float64) float64 { return math.E }, nil
+		}
+		return nil, p.errorf("unknown variable %q", tok)
+	}
+	return nil, p.errorf("unexpected %q", tok)
+}
+
+// call parses the arguments of a call to the function name.
+func (p *exprParser) call(name string) (exprFunc, error) {
+	f1, ok1 := exprFuncs1[name]
+	f2, ok2 := exprFuncs2[name]
+	if !ok1 && !ok2 {
+		return nil, p.errorf("unknown function %q", name)
+	}
+	if p.depth++; p.depth > maxExprDepth {
+		return nil, p.errorf("expression nested too deeply")
+	}
+	defer func() { p.depth-- }()
+	p.next()
+	var args []exprFunc
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok != "," {
+			break
+		}
+		p.next()
+	}
+	if p.tok != ")" {
+		return nil, p.errorf("missing ')'")
+	}
+	p.next()
+
+	switch {
+	case ok1 && len(args) == 1:
+		a := args[0]
+		return func(x, y float64) float64 { return f1(a(x, y)) }, nil
+	case ok2 && len(args) == 2:
+		a, b := args[0], args[1]
+		return func(x, y float64) float64 { return f2(a(x, y), b(x, y)) }, nil
+	}
+	want := 1
+	if ok2 {
+		want = 2
+	}
+	return nil, p.errorf("%s takes %d arguments, got %d", name, want, len(args))
+}
+
+func binaryOp(op string, f, g exprFunc) exprFunc {
+	switch op {
+	case "+":
+		return func(x, y float64) float64 { return f(x, y) + g(x, y) }
+	case "-":
+		return func(x, y float64) float64 { return f(x, y) - g(x, y) }
+	case "*":
+		return func(x, y float64) float64 { return f(x, y) * g(x, y) }
+	case "/":
+		return func(x, y float64) float64 { return f(x, y) / g(x, y) }
+	case "%":
+		return func(x, y float64) float64 { return math.Mod(f(x, y), g(x, y)) }
+	}
+	return func(x, y float64) float64 { return math.Pow(f(x, y), g(x, y)) }
+}
@@ -0,0 +1,78 @@
+package surface
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	const x, y = 1.5, -2.5
+	for _, c := range []struct {
+		src  string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"7 - 4 - 2", 1},
+		{"8 / 4 / 2", 1},
+		{"7 % 4", 3},
+		{"2 ^ 3 ^ 2", 512}, // right-associative
+		{"2 * 3 ^ 2", 18},
+		{"-2 ^ 2", -4}, // the power binds tighter than the minus
+		{"2 ^ -1", 0.5},
+		{"--x", x},
+		{"-+-x", x},
+		{"x * y", x * y},
+		{"r", math.Hypot(x, y)},
+		{"pi + e", math.Pi + math.E},
+		{"1e-3 + .5e1", 5.001},
+		{"sin(x) * cos(y) / 10", math.Sin(x) * math.Cos(y) / 10},
+		{"SQRT(abs(y))", math.Sqrt(math.Abs(y))},
+		{"atan2(y, x)", math.Atan2(y, x)},
+		{"max(x, min(y, 0))", math.Max(x, math.Min(y, 0))},
+		{"pow(x, 2) + hypot(3, 4)", x*x + 5},
+		{"log2(8) + log10(100) + log(e)", 6},
+		{strings.Repeat("(", maxExprDepth) + "x" + strings.Repeat(")", maxExprDepth), x},
+	} {
+		e, err := NewExprProjector(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		if got := e.f(x, y); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("%s = %g, want %g", c.src, got, c.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	for _, c := range []struct {
+		src, want string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(x", "missing ')'"},
+		{"sin(x", "missing ')'"},
+		{"x y", `unexpected "y"`},
+		{"x)", `unexpected ")"`},
+		{"2 $ 3", `unexpected "$"`},
+		{"*x", `unexpected "*"`},
+		{"1.2.3", `invalid number "1.2.3"`},
+		{"z", `unknown variable "z"`},
+		{"foo(x)", `unknown function "foo"`},
+		{"sin(x, y)", "sin takes 1 arguments, got 2"},
+		{"atan2(x)", "atan2 takes 2 arguments, got 1"},
+		{strings.Repeat("(", maxExprDepth+1) + "x" + strings.Repeat(")", maxExprDepth+1), "nested too deeply"},
+		{strings.Repeat("-", maxExprDepth+1) + "x", "nested too deeply"},
+		{strings.Repeat("abs(", maxExprDepth+1) + "x" + strings.Repeat(")", maxExprDepth+1), "nested too deeply"},
+		{strings.Repeat("x+", maxExprLen/2) + "x", "more than 1000"},
+	} {
+		_, err := NewExprProjector(c.src)
+		if err == nil {
+			t.Errorf("%.40s: no error, want %s", c.src, c.want)
+		} else if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%.40s: error %q, want %s", c.src, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package surface
+
+import "math"
+
+const (
+	defaultIterations = 100
+	maxIterations     = 10000
+	fractalScale      = 6 // x and y units per unit of the complex plane
+	fractalHeight     = 0.5
+)
+
+// defaultJulia is the constant c of the default Julia set.
+const defaultJulia = complex(-0.8, 0.156)
+
+// FractalProjector is the escape time of the points of the complex plane
+// under z ↦ z² + c: a Mandelbrot set, iterating from 0 with c the point,
+// or a Julia set, iterating from the point with a constant c. Heights rise
+// from 0 for points that escape at once to fractalHeight, about, for those
+// that do not escape within the iterations.
+type FractalProjector struct {
+	julia      bool
+	c          complex128
+	iterations int
+	zoom       float64
+}
+
+// NewMandelbrotProjector returns the Mandelbrot set with points iterated
+// up to iterations times, centered on -0.5 and magnified by zoom.
+func NewMandelbrotProjector(iterations int, zoom float64) FractalProjector {
+	return FractalProjector{iterations: iterations, zoom: zoom}
+}
+
+// NewJuliaProjector returns the Julia set of c, with points iterated up to
+// iterations times, centered on the origin and magnified by zoom.
+func NewJuliaProjector(c complex128, iterations int, zoom float64) FractalProjector {
+	return FractalProjector{julia: true, c: c, iterations: iterations, zoom: zoom}
+}
+
+func (f FractalProjector) Description() string {
+	if f.julia {
+		return "escape times of the Julia set of c"
+	}
+	return "escape times of the Mandelbrot set"
+}
+
+func (f FractalProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	p := complex(x, y) / complex(fractalScale*f.zoom, 0)
+	z, c := complex(0, 0), p
+	if f.julia {
+		z, c = p, f.c
+	} else {
+		c -= 0.5
+	}
+	for n := 0; n < f.iterations; n++ {
+		z = z*z + c
+		if r2 := real(z)*real(z) + imag(z)*imag(z); r2 > 256 {
+			// The fraction smooths the steps between escape times.
+			smooth := float64(n) + 1 - math.Log2(math.Log2(r2)/2)
+			return x, y, fractalHeight * max(smooth, 0) / float64(f.iterations)
+		}
+	}
+	return x, y, fractalHeight
+}
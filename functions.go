@@ -0,0 +1,218 @@
+package surface
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parameter documents a query parameter of a render.
+type parameter struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+}
+
+// parameters lists the query parameters ParseQuery accepts, in the order
+// it reads them.
+var parameters = []parameter{
+	{"function", "string", "sin", "name of the surface function, a combination such as 0.5*moguls-saddle, or heightmap to POST the heights; repeat or list with commas to draw several together"},
+	{"expr", "string", "", "expression in x, y and r to render instead of a named function"},
+	{"seed", "int", "0", "seed of the noise of function=terrain"},
+	{"octaves", "int", "6", "layers of noise of function=terrain, 1..16"},
+	{"roughness", "float", "0.5", "amplitude of each layer of noise of function=terrain relative to the last, (0, 1]"},
+	{"c", "string", "-0.8,0.156", "real and imaginary parts of the constant of function=julia"},
+	{"iterations", "int", "100", "most iterations of each point of function=mandelbrot or julia, 1..10000"},
+	{"fractalzoom", "float", "1", "magnification of the complex plane of function=mandelbrot or julia"},
+	{"l", "int", "3", "degree of the spherical harmonic of function=harmonic, 0..20"},
+	{"m", "int", "2", "order of the spherical harmonic of function=harmonic, -l..l"},
+	{"height", "int", "320", "canvas height in pixels, 50..4000"},
+	{"width", "int", "600", "canvas width in pixels, 50..4000"},
+	{"valley", "color", "ffffff", "color of the lowest cells: a CSS name, or hex rgb, rrggbb or rrggbbaa"},
+	{"peak", "color", "ffffff", "color of the highest cells"},
+	{"stops", "colors", "", "comma-separated gradient from the lowest to the highest cells"},
+	{"colors", "colors", "", "like stops, with optional positions in [0, 1] as in 0000ff@0,ff0000@1"},
+	{"colormap", "string", "", "built-in gradient: coolwarm, magma, plasma, turbo or viridis; a list gives one per function"},
+	{"diverging", "bool", "false", "center the gradient on the height center; coolwarm unless colors are set"},
+	{"center", "float", "0", "height at the middle of a diverging gradient"},
+	{"bands", "int", "0", "quantize the gradient into this many bands, 0..256; 0 is continuous"},
+	{"force", "bool", "false", "render even if peak and valley are the same color"},
+	{"rotate", "float", "0", "rotation about the z axis in degrees"},
+	{"azimuth", "float", "0", "camera turn about the z axis in degrees"},
+	{"elevation", "float", "35.26", "camera angle above the xy plane in degrees, (0, 90]"},
+	{"zoom", "float", "1", "magnification of the canvas, (0, 100]"},
+	{"view", "string", "surface", "surface, heatmap for a flat grid seen from above, slice for a chart of z along a cut, or stereo or anaglyph views for the two eyes (svg and png)"},
+	{"axis", "string", "y", "axis held constant along the cut of view=slice, x or y"},
+	{"at", "float", "", "value of axis at the cut of view=slice; defaults to the middle of its range"},
+	{"projection", "string", "orthographic", "orthographic or perspective"},
+	{"fov", "float", "60", "field of view of the perspective camera in degrees"},
+	{"distance", "float", "", "distance of the perspective camera; defaults to twice the domain"},
+	{"cells", "int", "100", "grid cells per side, 1..1000"},
+	{"cellsx", "int", "", "grid cells along x, 1..1000; defaults to cells"},
+	{"cellsy", "int", "", "grid cells along y, 1..1000; defaults to cells"},
+	{"range", "float", "30", "extent of the x and y axes"},
+	{"xrange", "float", "", "extent of the x axis, centered on 0; defaults to range"},
+	{"yrange", "float", "", "extent of the y axis, centered on 0; defaults to range"},
+	{"xmin", "float", "-15", "lower end of the x axis; defaults to -xrange/2"},
+	{"xmax", "float", "15", "upper end of the x axis; defaults to xrange/2"},
+	{"ymin", "float", "-15", "lower end of the y axis; defaults to -yrange/2"},
+	{"ymax", "float", "15", "upper end of the y axis; defaults to yrange/2"},
+	{"animate", "bool", "false", "loop through views rotating about the z axis"},
+	{"t", "float", "0", "moment of a surface that changes over time, such as wave, in loops"},
+	{"duration", "duration", "12s", "length of one loop of an animation or gif"},
+	{"frames", "int", "36", "views per loop of a gif, 2..360"},
+	{"precision", "int", "2", "decimal places of the coordinates, 0..10"},
+	{"flipy", "bool", "false", "mirror the canvas so its y axis grows upwards"},
+	{"zclamp", "string", "", "cap on the magnitude of z, or a range min,max to clamp z to"},
+	{"zmap", "string", "linear", "transform of z before projection and coloring: linear, log or sqrt"},
+	{"contours", "float", "", "interval in z between contour lines"},
+	{"contourlevels", "int", "", "number of evenly spaced contour lines, 1..1000, instead of contours"},
+	{"contourcolor", "color", "000000", "color of the contour lines"},
+	{"contourwidth", "float", "0.5", "width of the contour lines in pixels"},
+	{"scale", "float", "", "pixels per unit of x and y; defaults to fitting the domain across the width"},
+	{"zscale", "float", "", "pixels per unit of z; defaults to 0.4 of the height"},
+	{"fit", "bool", "false", "scale the surface to fill the canvas"},
+	{"padding", "float", "", "pixels around the surface with fit; defaults to 2% of its extent"},
+	{"wireframe", "bool", "false", "draw only the cell outlines"},
+	{"style", "string", "solid", "solid, or wireframe to draw only the cell outlines"},
+	{"background", "color", "transparent", "color behind the surface, or transparent"},
+	{"stroke", "color", "808080", "color of the cell outlines"},
+	{"stroke-width", "float", "0.7", "width of the cell outlines in pixels"},
+	{"stroke-opacity", "float", "1", "opacity of the cell outlines, (0, 1]"},
+	{"fill-opacity", "float", "1", "opacity of the cell fills, (0, 1], times the alpha of RRGGBBAA colors; a list gives one per function"},
+	{"tooltips", "bool", "false", "show the position and height of each cell on hover, and set them as data-x, data-y and data-z attributes"},
+	{"title", "string", "", "accessible name of an svg, in its <title>; defaults to the function"},
+	{"desc", "string", "", "accessible description of an svg, in its <desc>"},
+	{"created", "string", "", "RFC 3339 time of making an svg, for its metadata; left out by default"},
+	{"shading", "string", "none", "lambert (or true) to modulate fills by the lighting of each cell"},
+	{"merge", "bool", "false", "draw runs of cells of the same fill as one svg path"},
+	{"stream", "bool", "false", "write an svg as it is computed, uncached, in grid order"},
+	{"legend", "bool", "false", "draw a color bar of the heights (svg only)"},
+	{"cull", "bool", "false", "leave out cells facing away or hidden behind others"},
+	{"smooth", "int", "0", "radius in cells of a gaussian blur of the heights, 0..50; 0 is none"},
+	{"holes", "string", "skip", "cells with NaN or infinite corners: skip, interpolate from their neighbors, or fill with holecolor"},
+	{"holecolor", "color", "808080", "color of the cells of holes=fill"},
+	{"colorby", "string", "height", "height, or slope to color cells by the steepness of the surface"},
+	{"zmin", "float", "", "value at the low end of the color ramp, with zmax; defaults to the lowest"},
+	{"zmax", "float", "", "value at the high end of the color ramp, with zmin; defaults to the highest"},
+	{"colorrange", "string", "", "percentiles of the cell values the color ramp spans, such as p2,p98"},
+	{"gradient", "bool", "false", "fill each cell with a gradient between its corner colors (svg only)"},
+	{"light", "string", "180,45", "azimuth and elevation in degrees of the light"},
+	{"zfactor", "float", "1", "multiplier of the heights of obj, stl and gltf meshes"},
+	{"page", "string", "", "PDF page size: a3, a4, a5, letter or legal, with -landscape, or width x height in points"},
+	{"margin", "float", "0", "PDF page margin in points"},
+	{"format", "string", "svg", "svg, json, png, gif, pdf, obj, stl or gltf"},
+	{"adaptive", "float", "", "split cells where the surface bends by more than this fraction of its heights, (0, 1]; svg, png, gif and pdf"},
+	{"refine", "int", "3", "levels of splitting with adaptive, from blocks of 2^refine cells, 1..10"},
+	{"mesh", "string", "quad", "quad, or tri to draw each cell as two triangles with their own depth and shading; svg, png, gif and pdf"},
+	{"heightmap", "string", "", "URL of the heights of function=heightmap, on a host the server allows"},
+	{"download", "bool", "false", "have browsers save the rendering as a file"},
+	{"filename", "string", "", "name of the file, in which {cells} and the like stand for the parameters; function by default"},
+}
+
+// isParameter reports whether name is a query parameter of a render, one
+// of parameters or function.param of a Tunable projector.
+func isParameter(name string) bool {
+	if fn, _, ok := strings.Cut(name, "."); ok {
+		p, _ := LookupProjector(fn)
+		_, tunable := p.(Tunable)
+		return tunable
+	}
+	return slices.ContainsFunc(parameters, func(p parameter) bool { return p.Name == name })
+}
+
+// functionCells is the grid cells per side of the samples that find the
+// heights of the functions at /functions, coarse as they are only a guide.
+const functionCells = 32
+
+// function describes a registered projector sampled with the default
+// options on a coarse grid.
+type function struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	X           [2]float64 `json:"x"`
+	Y           [2]float64 `json:"y"`
+	Z           [2]float64 `json:"z"`
+	// U and V are the ranges of the parameters of a parametric surface.
+	U *[2]float64 `json:"u,omitempty"`
+	V *[2]float64 `json:"v,omitempty"`
+	// Params are those of a Tunable projector, set as name.param.
+	Params []ProjectorParam `json:"params,omitempty"`
+}
+
+// listFunctions lists the enabled functions and the parameters of a render
+// as JSON. The list is made once for each config, with the functions
+// registered by the first request.
+func (h *Handler) listFunctions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		WriteError(w, fmt.Errorf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := h.config.Load()
+	cfg.listing.Do(func() { cfg.functionList, cfg.listErr = functionList(cfg.functions) })
+	if cfg.listErr != nil {
+		WriteError(w, cfg.listErr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(cfg.functionList)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(cfg.functionList)
+}
+
+// functionList returns the JSON of the functions enabled, or of all of them
+// if enabled is nil, and of the parameters of a render.
+func functionList(enabled map[string]bool) ([]byte, error) {
+	var doc struct {
+		Functions  []function  `json:"functions"`
+		Parameters []parameter `json:"parameters"`
+	}
+	for _, name := range Projectors() {
+		if enabled != nil && !enabled[name] {
+			continue
+		}
+		p, _ := LookupProjector(name)
+		opts := DefaultOptions()
+		opts.Projector = p
+		opts.Cells = functionCells
+		var stats Stats
+		opts.Stats = &stats
+		if err := RenderContext(context.Background(), io.Discard, opts); err != nil {
+			return nil, fmt.Errorf("sampling function %s: %v", name, err)
+		}
+		f := function{Name: name, Z: [2]float64{stats.ZMin, stats.ZMax}}
+		xmin, xmax, ymin, ymax := opts.Domain()
+		if pp, ok := p.(ParametricProjector); ok {
+			umin, umax, vmin, vmax := pp.Domain()
+			f.U, f.V = &[2]float64{umin, umax}, &[2]float64{vmin, vmax}
+			e := pp.Extent()
+			xmin, xmax, ymin, ymax = -e, e, -e, e
+		}
+		f.X, f.Y = [2]float64{xmin, xmax}, [2]float64{ymin, ymax}
+		if d, ok := p.(Describer); ok {
+			f.Description = d.Description()
+		}
+		if t, ok := p.(Tunable); ok {
+			f.Params = t.Params()
+		}
+		doc.Functions = append(doc.Functions, f)
+	}
+	doc.Parameters = parameters
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
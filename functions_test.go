@@ -0,0 +1,43 @@
+package surface
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// functionNames returns the names of the functions listed by h.
+func functionNames(t *testing.T, h http.Handler) []string {
+	t.Helper()
+	var doc struct {
+		Functions []function `json:"functions"`
+	}
+	if err := json.Unmarshal(get(t, h, "/functions").Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range doc.Functions {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestListFunctions(t *testing.T) {
+	h := NewHandler(HandlerConfig{Functions: []string{"saddle", "sin"}})
+	if got := functionNames(t, h); len(got) != 2 || got[0] != "saddle" || got[1] != "sin" {
+		t.Errorf("functions %v, want [saddle sin]", got)
+	}
+	length := get(t, h, "/functions").Body.Len()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/functions", nil))
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(length) || w.Body.Len() != 0 {
+		t.Errorf("HEAD: Content-Length %q and a body of %d bytes, want %d and none", got, w.Body.Len(), length)
+	}
+	// A new config lists its own functions.
+	h.SetConfig(HandlerConfig{Functions: []string{"eggbox"}})
+	if got := functionNames(t, h); len(got) != 1 || got[0] != "eggbox" {
+		t.Errorf("functions %v after SetConfig, want [eggbox]", got)
+	}
+}
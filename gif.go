@@ -0,0 +1,68 @@
+package surface
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+)
+
+const gifFrames = 36 // default views per loop of an animated GIF
+
+// gifFrameCount returns the number of views of an animated GIF for opts.
+func (o Options) gifFrameCount() int {
+	if o.Frames > 0 {
+		return o.Frames
+	}
+	return gifFrames
+}
+
+// gifPalette is the web-safe palette plus a transparent background.
+var gifPalette = append(color.Palette{color.RGBA{}}, palette.WebSafe...)
+
+// animatedGIF writes a looping GIF of the surface for opts turning once
+// about the z axis, one view per frame, each shown for an equal share of
+// opts.Duration. The views share the viewBox of opts.Fit so the surface
+// does not jump between frames.
+func animatedGIF(ctx context.Context, w io.Writer, opts Options) error {
+	frames := opts.gifFrameCount()
+	meshes := make([]*mesh, frames)
+	b := emptyBounds()
+	for k := range meshes {
+		frame := opts
+		frame.Rotate = math.Mod(opts.Rotate+float64(k)*360/float64(frames), 360)
+		m, err := sample(ctx, frame)
+		if err != nil {
+			return err
+		}
+		meshes[k] = m
+		b.union(m.bounds)
+	}
+	defer opts.trace("encode")()
+	levels, err := contourLevels(b, opts)
+	if err != nil {
+		return err
+	}
+
+	r := image.Rect(0, 0, opts.Width, opts.Height)
+	tr := newTransform(b, opts)
+	delay := max(int(math.Round(opts.Duration.Seconds()*100/float64(frames))), 1)
+	anim := &gif.GIF{}
+	img := image.NewRGBA(r)
+	for _, m := range meshes {
+		fillBackground(img, opts)
+		if err := rasterize(ctx, img, m, levels, tr, opts); err != nil {
+			return err
+		}
+		frame := image.NewPaletted(r, gifPalette)
+		draw.FloydSteinberg.Draw(frame, r, img, image.Point{})
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
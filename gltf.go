@@ -0,0 +1,148 @@
+package surface
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// gltfMesh writes the surface for opts as a binary glTF 2.0 (.glb) file: a
+// single triangle mesh with per-vertex normals and colors from opts.Stops.
+// glTF is y-up, so the function's z axis becomes y.
+func gltfMesh(ctx context.Context, w io.Writer, opts Options) error {
+	s, err := newSolid(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	// Vertex normals are the average of the normals of adjacent triangles.
+	normals := make([][3]float64, len(s.vertices))
+	for _, t := range s.triangles {
+		n := s.normal(t)
+		for _, v := range t {
+			for k := range n {
+				normals[v][k] += n[k]
+			}
+		}
+	}
+	// Vertices are colored by height, or by the slope of their normals,
+	// undoing the ZFactor of the vertices.
+	values := s.heights
+	if opts.ColorBy == "slope" {
+		values = make([]float64, len(normals))
+		for v, n := range normals {
+			values[v] = math.Hypot(n[0], n[1]) / math.Abs(n[2]) / opts.ZFactor
+		}
+	}
+	vmin, vmax := math.Inf(1), math.Inf(-1)
+	for _, z := range values {
+		vmin, vmax = min(vmin, z), max(vmax, z)
+	}
+	vmin, vmax = opts.colorRange(vmin, vmax, values)
+
+	var bin bytes.Buffer
+	put := func(v any) { binary.Write(&bin, binary.LittleEndian, v) }
+	posMin := [3]float32{float32(math.Inf(1)), float32(math.Inf(1)), float32(math.Inf(1))}
+	posMax := [3]float32{float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1))}
+	for _, v := range s.vertices {
+		p := [3]float32{float32(v[0]), float32(v[2]), float32(-v[1])}
+		for k := range p {
+			posMin[k], posMax[k] = min(posMin[k], p[k]), max(posMax[k], p[k])
+		}
+		put(p)
+	}
+	normalOffset := bin.Len()
+	for _, n := range normals {
+		n = normalize(n)
+		if math.IsNaN(n[0]) {
+			n = [3]float64{0, 0, 1}
+		}
+		put([3]float32{float32(n[0]), float32(n[2]), float32(-n[1])})
+	}
+	colorOffset := bin.Len()
+	for _, z := range values {
+		c := opts.ramp(z, vmin, vmax)
+		put([3]float32{linear(c.R), linear(c.G), linear(c.B)})
+	}
+	indexOffset := bin.Len()
+	for _, t := range s.triangles {
+		put([3]uint32{uint32(t[0]), uint32(t[1]), uint32(t[2])})
+	}
+
+	n := len(s.vertices)
+	type obj = map[string]any
+	doc := obj{
+		"asset":  obj{"version": "2.0", "generator": "surface"},
+		"scene":  0,
+		"scenes": []obj{{"nodes": []int{0}}},
+		"nodes":  []obj{{"mesh": 0}},
+		"meshes": []obj{{"primitives": []obj{{
+			"attributes": obj{"POSITION": 0, "NORMAL": 1, "COLOR_0": 2},
+			"indices":    3,
+			"mode":       4, // triangles
+		}}}},
+		"buffers": []obj{{"byteLength": bin.Len()}},
+		"bufferViews": []obj{
+			{"buffer": 0, "byteOffset": 0, "byteLength": normalOffset, "target": 34962},
+			{"buffer": 0, "byteOffset": normalOffset, "byteLength": colorOffset - normalOffset, "target": 34962},
+			{"buffer": 0, "byteOffset": colorOffset, "byteLength": indexOffset - colorOffset, "target": 34962},
+			{"buffer": 0, "byteOffset": indexOffset, "byteLength": bin.Len() - indexOffset, "target": 34963},
+		},
+		"accessors": []obj{
+			{"bufferView": 0, "componentType": 5126, "count": n, "type": "VEC3", "min": posMin, "max": posMax},
+			{"bufferView": 1, "componentType": 5126, "count": n, "type": "VEC3"},
+			{"bufferView": 2, "componentType": 5126, "count": n, "type": "VEC3"},
+			{"bufferView": 3, "componentType": 5125, "count": 3 * len(s.triangles), "type": "SCALAR"},
+		},
+	}
+	if n == 0 {
+		// A mesh needs at least one vertex; drop it from an empty surface.
+		delete(doc, "meshes")
+		delete(doc, "accessors")
+		delete(doc, "bufferViews")
+		delete(doc, "buffers")
+		doc["nodes"] = []obj{{}}
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return writeGLB(w, js, bin.Bytes())
+}
+
+// writeGLB writes the glTF JSON and binary buffer as a GLB container.
+func writeGLB(w io.Writer, js, bin []byte) error {
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+	for len(bin)%4 != 0 {
+		bin = append(bin, 0)
+	}
+	length := 12 + 8 + len(js)
+	if len(bin) > 0 {
+		length += 8 + len(bin)
+	}
+	var b bytes.Buffer
+	put := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+	put([3]uint32{0x46546c67, 2, uint32(length)}) // "glTF", version 2
+	put([2]uint32{uint32(len(js)), 0x4e4f534a})   // "JSON"
+	b.Write(js)
+	if len(bin) > 0 {
+		put([2]uint32{uint32(len(bin)), 0x004e4942}) // "BIN"
+		b.Write(bin)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// linear converts an sRGB component to linear light, as glTF colors are.
+func linear(c uint8) float32 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return float32(v / 12.92)
+	}
+	return float32(math.Pow((v+0.055)/1.055, 2.4))
+}
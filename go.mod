@@ -0,0 +1,3 @@
+module github.com/mxschardt/surface
+
+go 1.22
@@ -0,0 +1,564 @@
+package surface
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bound the renders a Handler serves.
+type Limits struct {
+	MaxCanvas int           // largest width or height in pixels; 0 means 4000
+	MaxCells  int           // most grid cells per side; 0 means 1000
+	MaxMem    int64         // largest mesh in bytes; 0 means no limit
+	MaxBytes  int64         // largest response body in bytes; 0 means no limit
+	Timeout   time.Duration // longest a render may take; 0 means no limit
+}
+
+// HandlerConfig configures a Handler.
+type HandlerConfig struct {
+	Limits
+	// RequestLimits, if not nil, returns the limits of each request in
+	// place of Limits.
+	RequestLimits func(r *http.Request) Limits
+	// Defaults are the query parameters of the requests that leave them
+	// out. A request that sets one of a group of parameters that set the
+	// same thing, such as stops and colormap, leaves out the defaults of
+	// the whole group.
+	Defaults url.Values
+	// Functions are the names of the functions requests may ask for,
+	// including expr and heightmap; nil means all.
+	Functions []string
+	// HeightmapHosts are the hosts from which function=heightmap may fetch
+	// the heights at the URL of a heightmap parameter; nil means none.
+	HeightmapHosts []string
+	// Origins are the origins, or "*" for any, of the pages other than the
+	// Handler's own that may open a WebSocket on /ws. Browsers send the
+	// origin of the page, and handshakes from others fail with 403.
+	Origins []string
+	// CacheBytes is the size of the cache of rendered responses; 0 means
+	// none. MaxConcurrent is the most renders in progress at once, beyond
+	// which requests fail with 429 Too Many Requests; 0 means no limit.
+	// SetConfig does not change either.
+	CacheBytes    int
+	MaxConcurrent int
+	// Logger logs the renders that fail or are aborted, with the context
+	// of their request; nil means slog.Default().
+	Logger *slog.Logger
+	// Trace, if not nil, returns the Options.Trace of the render for r,
+	// which is also given the stage "parse" of the query.
+	Trace func(r *http.Request) func(stage string) (end func())
+	// Report, if not nil, is called as each render request is done.
+	Report func(r *http.Request, rep Report)
+}
+
+// Report describes a render request handled by a Handler.
+type Report struct {
+	Function  string // normalized name, or "unknown"
+	Duration  time.Duration
+	Stats     Stats
+	Status    int
+	Bytes     int  // of the response body
+	Cacheable bool // the response could come from the cache
+	Cached    bool // and did
+	Aborted   bool // the client went away
+}
+
+// Handler serves renders over HTTP. A GET request with the query
+// parameters of ParseQuery, or a POST of the heights of function=heightmap,
+// which a POST to /render/data implies, is answered with the rendering, or
+// with 4xx and a JSON list of the errors. A POST to /api/v1/render takes
+// the parameters in a JSON body instead, and a POST of a JSON array of them
+// to /api/v1/batch is answered with a zip archive of the renderings. A
+// WebSocket handshake on /ws is answered with renderings of increasing
+// resolution. GET /functions lists the functions and the parameters. Paths
+// are otherwise ignored, so a Handler can be mounted with http.StripPrefix:
+//
+//	mux.Handle("/plots/surface/", http.StripPrefix("/plots/surface", surface.NewHandler(cfg)))
+type Handler struct {
+	config atomic.Pointer[handlerConfig]
+	cache  *renderCache
+	slots  chan struct{} // a token for each render in progress, if limited
+}
+
+type handlerConfig struct {
+	HandlerConfig
+	functions map[string]bool
+	// listing makes functionList and listErr, the response to /functions,
+	// once for the config.
+	listing      sync.Once
+	functionList []byte
+	listErr      error
+}
+
+// NewHandler returns a Handler configured by cfg.
+func NewHandler(cfg HandlerConfig) *Handler {
+	h := &Handler{cache: newRenderCache(cfg.CacheBytes)}
+	if cfg.MaxConcurrent > 0 {
+		h.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	h.SetConfig(cfg)
+	return h
+}
+
+// SetConfig configures the requests that follow by cfg, except for its
+// CacheBytes and MaxConcurrent.
+func (h *Handler) SetConfig(cfg HandlerConfig) {
+	c := &handlerConfig{HandlerConfig: cfg}
+	if cfg.Functions != nil {
+		c.functions = make(map[string]bool)
+		for _, name := range cfg.Functions {
+			c.functions[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	h.config.Store(c)
+}
+
+// CacheSize returns the total size of the responses in the cache.
+func (h *Handler) CacheSize() int {
+	return h.cache.size()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/functions":
+		h.listFunctions(w, r)
+		return
+	case "/api/v1/render":
+		h.serveAPI(w, r)
+		return
+	case "/api/v1/batch":
+		h.serveBatch(w, r)
+		return
+	case "/render/data":
+		// The heights of measured data, rendered as function=heightmap.
+		u, r2 := *r.URL, *r
+		q := r.URL.Query()
+		q.Del("expr")
+		q.Set("function", "heightmap")
+		u.RawQuery = q.Encode()
+		r2.URL = &u
+		r = &r2
+	case "/ws":
+		h.serveWebSocket(w, r)
+		return
+	}
+	cfg := h.config.Load()
+	var rep Report
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	if cfg.Report != nil {
+		defer func() {
+			rep.Duration, rep.Status, rep.Bytes = time.Since(start), rec.status, rec.bytes
+			cfg.Report(r, rep)
+		}()
+	}
+	r, opts, lim, ok := h.prepare(w, r, cfg, &rep)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", ContentType(opts.Format))
+
+	var err error
+	// Renders stop when the client goes away, they run out of time or their
+	// output grows past the limit.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if lim.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), lim.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	defer cancel()
+	out := &limitWriter{n: lim.MaxBytes, stop: cancel}
+	if out.n <= 0 {
+		out.n = math.MaxInt64
+	}
+	fail := func(err error) {
+		if out.over {
+			WriteError(w, fmt.Errorf("response is larger than the limit of %d bytes", lim.MaxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			WriteError(w, fmt.Errorf("render took longer than the limit of %v", lim.Timeout), http.StatusServiceUnavailable)
+			return
+		}
+		WriteError(w, err, http.StatusBadRequest)
+	}
+	render := func(w io.Writer) error {
+		if !h.acquire() {
+			return errBusy
+		}
+		defer h.release()
+		out.w = w
+		err := RenderContext(ctx, out, opts)
+		if out.over {
+			err = errTooLarge
+		}
+		return err
+	}
+	busy := func() {
+		WriteError(w, fmt.Errorf("too many renders in progress, at most %d", cap(h.slots)), http.StatusTooManyRequests)
+	}
+
+	if opts.Stream {
+		// Send the cells as they are written, so without a Content-Length
+		// or ETag, and bypassing the cache.
+		if r.Method == http.MethodHead {
+			return
+		}
+		err = render(w)
+		if err == errBusy {
+			busy()
+			return
+		}
+		if r.Context().Err() != nil {
+			rep.Aborted = true
+			cfg.Logger.WarnContext(r.Context(), "render aborted", "error", err)
+			return
+		}
+		if err != nil && rec.bytes == 0 {
+			fail(err)
+		} else if err != nil {
+			cfg.Logger.ErrorContext(r.Context(), "render failed mid-stream", "bytes", rec.bytes, "error", err)
+		}
+		return
+	}
+
+	key := cacheKey(r)
+	entry, ok := h.cache.get(key)
+	rep.Cacheable, rep.Cached = key != "", ok && key != ""
+	if !ok || key == "" {
+		// Render into a buffer so the response carries a Content-Length.
+		var buf bytes.Buffer
+		err = render(&buf)
+		if err == errBusy {
+			busy()
+			return
+		}
+		if r.Context().Err() != nil {
+			rep.Aborted = true
+			cfg.Logger.WarnContext(r.Context(), "render aborted", "error", err)
+			return
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		entry = newCacheEntry(key, buf.Bytes())
+		if key != "" {
+			h.cache.add(entry)
+		}
+	}
+
+	body, etag := entry.body, entry.etag
+	w.Header().Set("ETag", etag)
+	if etagMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
+
+// prepare returns the options of the render r asks for, and its limits,
+// with the defaults of cfg in the URL of the returned request. If r is
+// invalid or exceeds the limits, it replies with the error and reports
+// false.
+func (h *Handler) prepare(w http.ResponseWriter, r *http.Request, cfg *handlerConfig, rep *Report) (*http.Request, Options, Limits, bool) {
+	trace := func(string) func() { return func() {} }
+	if cfg.Trace != nil {
+		if t := cfg.Trace(r); t != nil {
+			trace = t
+		}
+	}
+
+	parse := trace("parse")
+	q, changed := r.URL.Query(), false
+	if !q.Has("format") {
+		w.Header().Add("Vary", "Accept")
+		format, ok := negotiateFormat(r.Header.Get("Accept"))
+		if !ok {
+			parse()
+			WriteError(w, fmt.Errorf("cannot render any type of Accept %q; set 'format' or accept image/svg+xml", r.Header.Get("Accept")), http.StatusNotAcceptable)
+			return r, Options{}, Limits{}, false
+		}
+		if format != "" {
+			q.Set("format", format)
+			changed = true
+		}
+	}
+	if len(cfg.Defaults) > 0 {
+		q, changed = withDefaults(q, cfg.Defaults), true
+	}
+	if changed {
+		// Put the format and defaults in the URL for the cache key to
+		// cover them.
+		u, r2 := *r.URL, *r
+		u.RawQuery = q.Encode()
+		r2.URL = &u
+		r = &r2
+	}
+	opts, function, err := ParseQuery(r.URL.Query())
+	rep.Function = function
+	if err == nil && cfg.functions != nil {
+		names := []string{function}
+		switch function {
+		case "composite":
+			_, names, _ = parseComposite(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("function"))), LookupProjector)
+		case "overlay":
+			names = nil
+			for _, f := range listValues(r.URL.Query(), "function") {
+				f = strings.ToLower(f)
+				if _, ok := LookupProjector(f); !ok && isComposite(f) {
+					_, parts, _ := parseComposite(f, LookupProjector)
+					names = append(names, parts...)
+				} else {
+					names = append(names, f)
+				}
+			}
+		}
+		for _, name := range names {
+			if !cfg.functions[name] {
+				err = ParamErrors{{Param: "function", Message: fmt.Sprintf("'function'=%s is not enabled on this server", name)}}
+				break
+			}
+		}
+	}
+	disposition, derr := contentDisposition(r.URL.Query(), function, opts.Format)
+	if derr != nil {
+		var errs ParamErrors
+		errors.As(err, &errs)
+		err = append(errs, derr.(ParamErrors)...)
+	}
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	parse()
+	if err != nil {
+		WriteError(w, err, http.StatusBadRequest)
+		return r, opts, Limits{}, false
+	}
+	if function == "heightmap" {
+		if src := r.URL.Query().Get("heightmap"); src != "" {
+			opts.Projector, err = fetchHeightmap(r.Context(), src, cfg.HeightmapHosts, max(opts.Cells, opts.cellsY()))
+		} else if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			WriteError(w, errors.New("'function'=heightmap needs the heights in a POST body or at a 'heightmap' URL"), http.StatusMethodNotAllowed)
+			return r, opts, Limits{}, false
+		} else {
+			opts.Projector, err = parseHeightmap(w, r, max(opts.Cells, opts.cellsY()))
+		}
+		if err != nil {
+			WriteError(w, err, http.StatusBadRequest)
+			return r, opts, Limits{}, false
+		}
+	}
+	lim := cfg.Limits
+	if cfg.RequestLimits != nil {
+		lim = cfg.RequestLimits(r)
+	}
+	if err := lim.check(opts); err != nil {
+		WriteError(w, err, http.StatusRequestEntityTooLarge)
+		return r, opts, lim, false
+	}
+	if need := opts.MeshBytes(); lim.MaxMem > 0 && need > lim.MaxMem {
+		WriteError(w, fmt.Errorf("render needs %d bytes of mesh, more than the limit of %d", need, lim.MaxMem), http.StatusRequestEntityTooLarge)
+		return r, opts, lim, false
+	}
+	opts.Stats = &rep.Stats
+	opts.Trace = trace
+	return r, opts, lim, true
+}
+
+// ContentType returns the media type of renderings in format.
+func ContentType(format string) string {
+	switch format {
+	case "json":
+		return "application/json"
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	case "pdf":
+		return "application/pdf"
+	case "obj":
+		return "model/obj"
+	case "stl":
+		return "model/stl"
+	case "gltf":
+		return "model/gltf-binary"
+	}
+	return "image/svg+xml"
+}
+
+// WriteError replies to the request with status and a JSON body listing
+// the errors in err, each with the parameter it concerns if err is a
+// ParamErrors: {"errors":[{"param":"height","message":"..."}]}.
+func WriteError(w http.ResponseWriter, err error, status int) {
+	var errs ParamErrors
+	if !errors.As(err, &errs) {
+		errs = ParamErrors{{Message: err.Error()}}
+	}
+	hdr := w.Header()
+	hdr.Del("Content-Length")
+	hdr.Del("ETag")
+	hdr.Del("Content-Disposition")
+	hdr.Set("Content-Type", "application/json")
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors ParamErrors `json:"errors"`
+	}{errs})
+}
+
+var (
+	errBusy     = errors.New("too many renders in progress")
+	errTooLarge = errors.New("response too large") // returned by writes to a limitWriter past its limit
+)
+
+// acquire reserves a slot for a render, reporting false if all are taken.
+// A successful acquire must be followed by release.
+func (h *Handler) acquire() bool {
+	if h.slots == nil {
+		return true
+	}
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Handler) release() {
+	if h.slots != nil {
+		<-h.slots
+	}
+}
+
+// check reports the parameters of opts that exceed lim.
+func (lim Limits) check(opts Options) error {
+	canvas, cells := lim.MaxCanvas, lim.MaxCells
+	if canvas <= 0 {
+		canvas = MaxCanvas
+	}
+	if cells <= 0 {
+		cells = MaxCells
+	}
+	var errs ParamErrors
+	if opts.Width > canvas {
+		errs.add("width", fmt.Errorf("'width' %d is more than the limit of %d pixels", opts.Width, canvas))
+	}
+	if opts.Height > canvas {
+		errs.add("height", fmt.Errorf("'height' %d is more than the limit of %d pixels", opts.Height, canvas))
+	}
+	if opts.Cells > cells {
+		errs.add("cells", fmt.Errorf("'cells' %d is more than the limit of %d", opts.Cells, cells))
+	}
+	if opts.CellsY > cells {
+		errs.add("cellsy", fmt.Errorf("'cellsy' %d is more than the limit of %d", opts.CellsY, cells))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// limitWriter passes at most n bytes to w. The first write past the limit
+// is dropped and calls stop, which cancels the render rather than let it
+// run on to no purpose.
+type limitWriter struct {
+	w    io.Writer
+	n    int64
+	over bool
+	stop context.CancelFunc
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.over || int64(len(p)) > l.n {
+		if !l.over {
+			l.over = true
+			l.stop()
+		}
+		return 0, errTooLarge
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// exclusive are groups of parameters that set the same thing.
+var exclusive = [][]string{
+	{"function", "expr"},
+	{"valley", "peak", "stops", "colors", "colormap"},
+	{"contours", "contourlevels"},
+	{"wireframe", "style"},
+	{"animate", "t"},
+	{"zmin", "zmax", "colorrange"},
+}
+
+// withDefaults returns q with the defaults of the parameters it leaves out,
+// leaving out those of a group in exclusive if q sets any of it.
+func withDefaults(q, defaults url.Values) url.Values {
+	set := func(name string) bool {
+		for _, group := range exclusive {
+			if slices.Contains(group, name) {
+				return slices.ContainsFunc(group, q.Has)
+			}
+		}
+		return q.Has(name)
+	}
+	for name, v := range defaults {
+		if !set(name) {
+			q[name] = v
+		}
+	}
+	return q
+}
+
+// recorder remembers the status and body size of a response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += n
+	return n, err
+}
+
+// Hijack takes over the connection, as for a WebSocket, which switches
+// protocols.
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rec.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
@@ -0,0 +1,88 @@
+package surface
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandlerCachesRenders(t *testing.T) {
+	var renders atomic.Int32
+	h := NewHandler(HandlerConfig{
+		CacheBytes: 64 << 20,
+		Trace: func(*http.Request) func(string) func() {
+			return func(stage string) func() {
+				if stage == "sample" {
+					renders.Add(1)
+				}
+				return func() {}
+			}
+		},
+	})
+	first := get(t, h, "/?cells=20")
+	second := get(t, h, "/?cells=20")
+	if n := renders.Load(); n != 1 {
+		t.Errorf("two identical requests rendered %d times, want once", n)
+	}
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Error("the cached response differs from the rendered one")
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	w := get(t, h, "/?cells=20", "If-None-Match", etag)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, want 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("304 has a body of %d bytes", w.Body.Len())
+	}
+}
+
+func TestHandlerHead(t *testing.T) {
+	h := NewHandler(HandlerConfig{})
+	body := get(t, h, "/?cells=20").Body.Len()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/?cells=20", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD: status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(body) {
+		t.Errorf("Content-Length %q, want %d", got, body)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD has a body of %d bytes", w.Body.Len())
+	}
+}
+
+func TestHandlerMaxMem(t *testing.T) {
+	var sampled bool
+	h := NewHandler(HandlerConfig{
+		Limits: Limits{MaxMem: 1 << 20},
+		Trace: func(*http.Request) func(string) func() {
+			return func(stage string) func() {
+				sampled = sampled || stage == "sample"
+				return func() {}
+			}
+		},
+	})
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?cells=1000", nil))
+	runtime.ReadMemStats(&after)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", w.Code)
+	}
+	if sampled {
+		t.Error("the refused render was sampled")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("the refused render allocated %d bytes", n)
+	}
+}
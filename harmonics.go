@@ -0,0 +1,97 @@
+package surface
+
+import "math"
+
+const (
+	maxDegree     = 20 // highest degree l of a spherical harmonic
+	defaultDegree = 3
+	defaultOrder  = 2
+)
+
+// HarmonicProjector is a sphere deformed by the real spherical harmonic
+// Y(l,m): its radius swells where Y is positive and shrinks where it is
+// negative, by up to half.
+type HarmonicProjector struct {
+	l, m int
+	k    float64 // normalization of Y
+	ymax float64 // largest |Y|, about
+}
+
+// NewHarmonicProjector returns the sphere deformed by Y(l,m), with l in
+// 0..20 and m in -l..l.
+func NewHarmonicProjector(l, m int) HarmonicProjector {
+	l = max(0, min(l, maxDegree))
+	m = max(-l, min(m, l))
+	am := m
+	if am < 0 {
+		am = -am
+	}
+	// (l-|m|)!/(l+|m|)!
+	ratio := 1.0
+	for k := l - am + 1; k <= l+am; k++ {
+		ratio /= float64(k)
+	}
+	h := HarmonicProjector{l: l, m: m, k: math.Sqrt(float64(2*l+1) / (4 * math.Pi) * ratio)}
+	if m != 0 {
+		h.k *= math.Sqrt2
+	}
+	const samples = 64
+	for i := 0; i <= samples; i++ {
+		for j := 0; j <= samples; j++ {
+			y := h.y(2*math.Pi*float64(i)/samples, math.Pi*(float64(j)/samples-0.5))
+			h.ymax = max(h.ymax, math.Abs(y))
+		}
+	}
+	if h.ymax == 0 {
+		h.ymax = 1
+	}
+	return h
+}
+
+func (HarmonicProjector) Description() string {
+	return "a sphere deformed by the spherical harmonic Y(l,m)"
+}
+
+func (HarmonicProjector) Domain() (float64, float64, float64, float64) {
+	return 0, 2 * math.Pi, -math.Pi / 2, math.Pi / 2
+}
+
+func (HarmonicProjector) Extent() float64 { return 1.5 * sphereRadius }
+
+func (h HarmonicProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	u, v := g.Corner(i, j) // longitude and latitude
+	r := sphereRadius * (1 + 0.5*h.y(u, v)/h.ymax)
+	sinV, cosV := math.Sincos(v)
+	sinU, cosU := math.Sincos(u)
+	return r * cosV * cosU, r * cosV * sinU, r * sinV
+}
+
+// y is Y(l,m) at longitude u and latitude v.
+func (h HarmonicProjector) y(u, v float64) float64 {
+	switch {
+	case h.m > 0:
+		return h.k * legendre(h.l, h.m, math.Sin(v)) * math.Cos(float64(h.m)*u)
+	case h.m < 0:
+		return h.k * legendre(h.l, -h.m, math.Sin(v)) * math.Sin(float64(-h.m)*u)
+	}
+	return h.k * legendre(h.l, 0, math.Sin(v))
+}
+
+// legendre is the associated Legendre polynomial P(l,m) at x in [-1, 1],
+// for 0 ≤ m ≤ l.
+func legendre(l, m int, x float64) float64 {
+	pmm := 1.0 // P(m,m)
+	s, fact := math.Sqrt((1-x)*(1+x)), 1.0
+	for range m {
+		pmm *= -fact * s
+		fact += 2
+	}
+	if l == m {
+		return pmm
+	}
+	pm1 := x * float64(2*m+1) * pmm // P(m+1,m)
+	for n := m + 2; n <= l; n++ {
+		pmm, pm1 = pm1, (x*float64(2*n-1)*pm1-float64(n+m-1)*pmm)/float64(n-m)
+	}
+	return pm1
+}
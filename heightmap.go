@@ -0,0 +1,228 @@
+package surface
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeightmapProjector samples z from a rectangular grid of heights instead of
+// a function. Row r of the grid lies along x and column c along y; the grid
+// is stretched over the whole domain and bilinearly interpolated.
+type HeightmapProjector struct {
+	z [][]float64
+}
+
+// NewHeightmapProjector returns a projector for the heights z, indexed
+// [row][column]. The grid needs at least two rows and two columns, and every
+// row must have the same length.
+func NewHeightmapProjector(z [][]float64) (HeightmapProjector, error) {
+	if len(z) < 2 {
+		return HeightmapProjector{}, fmt.Errorf("heightmap has %d rows, need at least 2", len(z))
+	}
+	for k, row := range z {
+		if len(row) != len(z[0]) {
+			return HeightmapProjector{}, fmt.Errorf("heightmap row %d has %d values, row 0 has %d", k, len(row), len(z[0]))
+		}
+	}
+	if len(z[0]) < 2 {
+		return HeightmapProjector{}, fmt.Errorf("heightmap has %d columns, need at least 2", len(z[0]))
+	}
+	return HeightmapProjector{z: z}, nil
+}
+
+func (h HeightmapProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	u := float64(i) / float64(g.nx) * float64(len(h.z)-1)
+	v := float64(j) / float64(g.ny) * float64(len(h.z[0])-1)
+	r, c := min(int(u), len(h.z)-2), min(int(v), len(h.z[0])-2)
+	fu, fv := u-float64(r), v-float64(c)
+	z := h.z[r][c]*(1-fu)*(1-fv) + h.z[r+1][c]*fu*(1-fv) +
+		h.z[r][c+1]*(1-fu)*fv + h.z[r+1][c+1]*fu*fv
+	return x, y, z
+}
+
+const maxHeightmapBytes = 8 << 20 // limit on uploaded heightmap bodies
+
+// maxHeightmapPixels is the most pixels of a heightmap image.
+const maxHeightmapPixels = 16 << 20
+
+// parseHeightmap reads the heightmap uploaded in the body of r: JSON with
+// Content-Type application/json, an image with image/png, image/jpeg or
+// image/gif, and CSV otherwise. Images are resampled to at most cells+1
+// pixels per side.
+func parseHeightmap(w http.ResponseWriter, r *http.Request, cells int) (HeightmapProjector, error) {
+	body := http.MaxBytesReader(w, r.Body, maxHeightmapBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return readHeightmapType(body, mediaType, cells)
+}
+
+func readHeightmapType(r io.Reader, mediaType string, cells int) (HeightmapProjector, error) {
+	switch mediaType {
+	case "image/png", "image/jpeg", "image/gif":
+		return ReadImageHeightmap(r, cells)
+	}
+	return ReadHeightmap(r, mediaType == "application/json")
+}
+
+// heightmapClient fetches the heightmaps of heightmap=URL.
+var heightmapClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchHeightmap reads the heightmap at rawURL, whose host must be one of
+// hosts, as must those it redirects to. Its format is that of its
+// Content-Type, as for an upload.
+func fetchHeightmap(ctx context.Context, rawURL string, hosts []string, cells int) (HeightmapProjector, error) {
+	allowed := func(u *url.URL) error {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("'heightmap' URL %q is not http or https", u.Redacted())
+		}
+		if !slices.Contains(hosts, u.Hostname()) {
+			return fmt.Errorf("'heightmap' URL host %q is not allowed on this server", u.Hostname())
+		}
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return HeightmapProjector{}, fmt.Errorf("cannot parse 'heightmap' URL %q", rawURL)
+	}
+	if err := allowed(u); err != nil {
+		return HeightmapProjector{}, err
+	}
+	client := *heightmapClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return allowed(req.URL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return HeightmapProjector{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return HeightmapProjector{}, fmt.Errorf("cannot fetch 'heightmap': %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return HeightmapProjector{}, fmt.Errorf("cannot fetch 'heightmap': %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return readHeightmapType(io.LimitReader(resp.Body, maxHeightmapBytes), mediaType, cells)
+}
+
+// ReadImageHeightmap reads a projector for the heights of the PNG, JPEG or
+// GIF image in r, from 0 for black to 1 for white by luminance, with row
+// r of the grid being pixel row r. Images with more than cells+1 pixels on
+// a side are scaled down by averaging, unless cells is 0.
+func ReadImageHeightmap(r io.Reader, cells int) (HeightmapProjector, error) {
+	br := bufio.NewReader(r)
+	peek, _ := br.Peek(64 << 10)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(peek))
+	if err != nil {
+		return HeightmapProjector{}, fmt.Errorf("cannot decode heightmap image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxHeightmapPixels {
+		return HeightmapProjector{}, fmt.Errorf("heightmap image is %dx%d pixels, more than %d", cfg.Width, cfg.Height, maxHeightmapPixels)
+	}
+	img, _, err := image.Decode(br)
+	if err != nil {
+		return HeightmapProjector{}, fmt.Errorf("cannot decode heightmap image: %v", err)
+	}
+	b := img.Bounds()
+	rows, cols := b.Dy(), b.Dx()
+	if cells > 0 {
+		rows, cols = min(rows, cells+1), min(cols, cells+1)
+	}
+	z := make([][]float64, rows)
+	for k := range z {
+		z[k] = make([]float64, cols)
+		y0, y1 := b.Min.Y+k*b.Dy()/rows, b.Min.Y+(k+1)*b.Dy()/rows
+		for l := range z[k] {
+			x0, x1 := b.Min.X+l*b.Dx()/cols, b.Min.X+(l+1)*b.Dx()/cols
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += float64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+				}
+			}
+			z[k][l] = sum / float64((y1-y0)*(x1-x0)) / 0xffff
+		}
+	}
+	return NewHeightmapProjector(z)
+}
+
+// ReadHeightmap reads a projector for the heights in r. If isJSON is set,
+// r holds an array of rows, each an array of numbers:
+//
+//	[[0, 0.1, 0.2], [0.1, 0.4, 0.3], [0, 0.2, 0.1]]
+//
+// Otherwise it is CSV with one row per line, or TSV if the first line has
+// tabs and no commas:
+//
+//	0,0.1,0.2
+//	0.1,0.4,0.3
+//	0,0.2,0.1
+//
+// The grid needs at least two rows and two columns, and every row must have
+// the same length.
+func ReadHeightmap(r io.Reader, isJSON bool) (HeightmapProjector, error) {
+	var z [][]float64
+	if isJSON {
+		if err := json.NewDecoder(r).Decode(&z); err != nil {
+			return HeightmapProjector{}, fmt.Errorf("cannot decode JSON heightmap: %v", err)
+		}
+	} else {
+		var err error
+		if z, err = readCSVHeightmap(r); err != nil {
+			return HeightmapProjector{}, err
+		}
+	}
+	return NewHeightmapProjector(z)
+}
+
+func readCSVHeightmap(r io.Reader) ([][]float64, error) {
+	br := bufio.NewReader(r)
+	first, _ := br.Peek(4096)
+	if k := bytes.IndexByte(first, '\n'); k >= 0 {
+		first = first[:k]
+	}
+	cr := csv.NewReader(br)
+	if bytes.IndexByte(first, '\t') >= 0 && bytes.IndexByte(first, ',') < 0 {
+		cr.Comma = '\t'
+	}
+	cr.FieldsPerRecord = -1 // ragged rows are reported by NewHeightmapProjector
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV heightmap: %v", err)
+	}
+	z := make([][]float64, len(records))
+	for k, record := range records {
+		z[k] = make([]float64, len(record))
+		for l, field := range record {
+			z[k][l], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse heightmap value %q at row %d, column %d", field, k, l)
+			}
+		}
+	}
+	return z, nil
+}
@@ -0,0 +1,98 @@
+package surface
+
+import (
+	"context"
+	"math"
+)
+
+// filled is a sampled surface whose NaN or infinite heights were filled in
+// from their neighbors, remembering which were.
+type filled struct {
+	sampled
+	hole []bool // of corner (i,j) at i*(ny+1)+j
+}
+
+// isHole reports whether the height of corner (i,j) was filled in.
+func (f filled) isHole(i, j int) bool {
+	return f.hole[i*(f.ny+1)+j]
+}
+
+// fillHoles samples the corners of opts and replaces the heights that are
+// NaN or infinite by the average of their neighbors, working inward from
+// the edge of each hole a ring at a time. Heights with no finite height in
+// reach, as on a surface without any, stay so.
+func fillHoles(ctx context.Context, opts Options) (filled, error) {
+	defer opts.trace("holes")()
+	s, err := sampleCorners(ctx, opts)
+	if err != nil {
+		return filled{}, err
+	}
+	ni, nj := opts.Cells+1, opts.cellsY()+1
+	f := filled{sampled: s, hole: make([]bool, ni*nj)}
+	done := make([]bool, ni*nj) // finite, or filled in
+	neighbors := func(k int, visit func(int)) {
+		i, j := k/nj, k%nj
+		if i > 0 {
+			visit(k - nj)
+		}
+		if i < ni-1 {
+			visit(k + nj)
+		}
+		if j > 0 {
+			visit(k - 1)
+		}
+		if j < nj-1 {
+			visit(k + 1)
+		}
+	}
+	for k, z := range s.z {
+		f.hole[k] = math.IsNaN(z) || math.IsInf(z, 0)
+		done[k] = !f.hole[k]
+	}
+	queued := make([]bool, ni*nj)
+	var ring []int
+	for k := range s.z {
+		if !f.hole[k] {
+			continue
+		}
+		neighbors(k, func(n int) {
+			if done[n] && !queued[k] {
+				queued[k] = true
+				ring = append(ring, k)
+			}
+		})
+	}
+	for len(ring) > 0 {
+		if err := ctx.Err(); err != nil {
+			return filled{}, err
+		}
+		// Each ring is filled from the rings outside it only, so that
+		// holes fill in evenly from every side.
+		zs := make([]float64, len(ring))
+		for r, k := range ring {
+			var sum float64
+			var count int
+			neighbors(k, func(n int) {
+				if done[n] {
+					sum += s.z[n]
+					count++
+				}
+			})
+			zs[r] = sum / float64(count)
+		}
+		for r, k := range ring {
+			s.z[k], done[k] = zs[r], true
+		}
+		var next []int
+		for _, k := range ring {
+			neighbors(k, func(n int) {
+				if !done[n] && !queued[n] {
+					queued[n] = true
+					next = append(next, n)
+				}
+			})
+		}
+		ring = next
+	}
+	return f, nil
+}
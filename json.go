@@ -0,0 +1,120 @@
+package surface
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// meshDocument is the payload of format=json. Its fields are a stable API:
+//
+//	{
+//	  "cells": 100,             // grid cells per side, or along x
+//	  "cellsy": 50,             // grid cells along y, if not cells
+//	  "width": 600,             // canvas size the points are projected onto
+//	  "height": 320,
+//	  "zmin": -0.21, "zmax": 1, // range of corner heights
+//	  "x": [x0, x1, ...],       // grid coordinates along each axis
+//	  "y": [y0, y1, ...],
+//	  "z": [[z00, z10, ...], ...],
+//	  "vertices": [[[x, y, z], ...], ...],
+//	  "polygons": [[{"z": 0.1, "points": [ax, ay, bx, by, cx, cy, dx, dy]}, ...], ...]
+//	}
+//
+// x and y hold the cells+1 and cellsy+1 coordinates of the grid lines, and
+// z the height at every corner indexed [j][i], so that each row runs along
+// x, which is the layout of Plotly's surface trace and similar grid-based
+// renderers. vertices holds the same (cells+1)×(cellsy+1) grid corners as
+// returned by the function, indexed [i][j], before any rotation. polygons
+// holds the cells×cellsy projected cells, indexed [i][j], with their
+// average height and the canvas coordinates of corners (i+1,j), (i,j),
+// (i,j+1), (i+1,j+1).
+// Values that are NaN or infinite, and cells containing them, are null.
+type meshDocument struct {
+	Cells    int                `json:"cells"`
+	CellsY   int                `json:"cellsy,omitempty"`
+	Width    int                `json:"width"`
+	Height   int                `json:"height"`
+	Zmin     jsonFloat          `json:"zmin"`
+	Zmax     jsonFloat          `json:"zmax"`
+	X        []jsonFloat        `json:"x"`
+	Y        []jsonFloat        `json:"y"`
+	Z        [][]jsonFloat      `json:"z"`
+	Vertices [][][3]jsonFloat   `json:"vertices"`
+	Polygons [][]*polygonObject `json:"polygons"`
+}
+
+type polygonObject struct {
+	Z      jsonFloat    `json:"z"`
+	Points [8]jsonFloat `json:"points"`
+}
+
+// jsonFloat is a float64 that encodes NaN and ±Inf as null.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, float64(f), 'g', -1, 64), nil
+}
+
+// meshJSON writes the sampled surface for opts to w as a meshDocument.
+func meshJSON(ctx context.Context, w io.Writer, opts Options) error {
+	m, err := sample(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+
+	nx, ny := opts.Cells, opts.cellsY()
+	doc := meshDocument{
+		Cells:    nx,
+		Width:    opts.Width,
+		Height:   opts.Height,
+		Zmin:     jsonFloat(m.zmin),
+		Zmax:     jsonFloat(m.zmax),
+		X:        make([]jsonFloat, nx+1),
+		Y:        make([]jsonFloat, ny+1),
+		Z:        make([][]jsonFloat, ny+1),
+		Vertices: make([][][3]jsonFloat, nx+1),
+		Polygons: make([][]*polygonObject, nx),
+	}
+	if ny != nx {
+		doc.CellsY = ny
+	}
+	g := opts.grid()
+	for j := range doc.Z {
+		doc.Z[j] = make([]jsonFloat, nx+1)
+	}
+	for i := range doc.Vertices {
+		doc.Vertices[i] = make([][3]jsonFloat, ny+1)
+		for j := range doc.Vertices[i] {
+			x, y, z := opts.Projector.Corner(g, i, j)
+			doc.Vertices[i][j] = [3]jsonFloat{jsonFloat(x), jsonFloat(y), jsonFloat(z)}
+			doc.Z[j][i] = jsonFloat(z)
+			if j == 0 {
+				doc.X[i] = jsonFloat(x)
+			}
+			if i == 0 {
+				doc.Y[j] = jsonFloat(y)
+			}
+		}
+	}
+	for i := range doc.Polygons {
+		doc.Polygons[i] = make([]*polygonObject, ny)
+		for j, p := range m.polygons[i] {
+			if !p.valid {
+				continue
+			}
+			obj := &polygonObject{Z: jsonFloat(p.z)}
+			for k, v := range p.points {
+				obj.Points[k] = jsonFloat(v)
+			}
+			doc.Polygons[i][j] = obj
+		}
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,71 @@
+package surface
+
+import (
+	"context"
+	"image/color"
+	"slices"
+)
+
+// maxLayers is the most Layers of a render.
+const maxLayers = 8
+
+// Layer is a surface drawn together with the Projector of Options, in
+// colors of its own.
+type Layer struct {
+	Projector Projector
+	Stops     []color.RGBA // nil means the Stops and Offsets of Options
+	Opacity   float64      // factor of the alpha of Stops; 0 means 1
+	offsets   []float64
+}
+
+// withLayerColors returns o with the Stops of its Layers filled in and
+// their Opacity applied, in a copy of Layers.
+func (o Options) withLayerColors() Options {
+	o.Layers = slices.Clone(o.Layers)
+	for k, l := range o.Layers {
+		if l.Stops == nil {
+			l.Stops, l.offsets = o.Stops, o.Offsets
+		}
+		if l.Opacity > 0 && l.Opacity < 1 {
+			stops := make([]color.RGBA, len(l.Stops))
+			for s, c := range l.Stops {
+				c.A = uint8(float64(c.A)*l.Opacity + 0.5)
+				stops[s] = c
+			}
+			l.Stops = stops
+		}
+		o.Layers[k] = l
+	}
+	return o
+}
+
+// sampleLayers samples the Layers of opts and adds their cells to m, as
+// rows after its own.
+func sampleLayers(ctx context.Context, opts Options, m *mesh) error {
+	for k, l := range opts.Layers {
+		lo := opts
+		lo.Projector, lo.Layers = l.Projector, nil
+		lm, err := sampleGrid(ctx, lo)
+		if err != nil {
+			return err
+		}
+		for i := range lm.polygons {
+			for j := range lm.polygons[i] {
+				lm.polygons[i][j].layer = uint16(k + 1)
+			}
+		}
+		m.polygons = append(m.polygons, lm.polygons...)
+		m.bounds.union(lm.bounds)
+	}
+	return nil
+}
+
+// layerRamp returns the color of value v, in the range vmin..vmax, on the
+// ramp of the given layer of o, 0 being its own.
+func (o Options) layerRamp(layer uint16, v, vmin, vmax float64) color.RGBA {
+	if layer == 0 {
+		return o.ramp(v, vmin, vmax)
+	}
+	l := o.Layers[layer-1]
+	return o.rampOf(v, vmin, vmax, l.Stops, l.offsets)
+}
@@ -0,0 +1,59 @@
+package surface
+
+import (
+	"fmt"
+	"io"
+)
+
+const legendStops = 32 // samples of the color ramp in the legend
+
+// legend writes a vertical color bar near the right edge of the view of b
+// showing the color ramp of opts from b.vmin at the bottom to b.vmax at the
+// top, labelled with the heights, or slopes, at its ends and middle. Heights
+// are labelled before the ZMap of opts.
+func legend(w io.Writer, b bounds, opts Options) {
+	if b.vmax < b.vmin {
+		return // nothing was drawn
+	}
+	vx, vy, vw, vh := 0.0, 0.0, float64(opts.Width), float64(opts.Height)
+	if opts.Fit {
+		vx, vy, vw, vh = b.viewRect(opts.Width, opts.Height, opts.Padding)
+	}
+	bw, bh := 0.03*vw, 0.6*vh
+	bx, by := vx+0.97*vw-bw, vy+0.2*vh
+	size := 0.04 * vh
+
+	fmt.Fprint(w, "<g class='legend'><linearGradient id='legend' x1='0' y1='1' x2='0' y2='0'>")
+	stop := func(t, z float64) {
+		c := opts.ramp(z, b.vmin, b.vmax)
+		fmt.Fprintf(w, "<stop offset='%.4g' stop-color='#%02x%02x%02x'/>", t, c.R, c.G, c.B)
+	}
+	if opts.Bands > 0 {
+		// Hard edges between the bands.
+		n := float64(opts.Bands)
+		for k := 0.0; k < n; k++ {
+			z := b.vmin + (k+0.5)/n*(b.vmax-b.vmin)
+			stop(k/n, z)
+			stop((k+1)/n, z)
+		}
+	} else {
+		for k := 0; k < legendStops; k++ {
+			t := float64(k) / (legendStops - 1)
+			stop(t, b.vmin+t*(b.vmax-b.vmin))
+		}
+	}
+	fmt.Fprint(w, "</linearGradient>")
+	fmt.Fprintf(w, "<rect x='%g' y='%g' width='%g' height='%g' fill='url(#legend)' stroke='grey' stroke-width='%g'/>",
+		bx, by, bw, bh, 0.002*vw)
+	for k := 0; k <= 2; k++ {
+		t := float64(k) / 2
+		v := b.vmin + t*(b.vmax-b.vmin)
+		if opts.ColorBy != "slope" {
+			v = opts.unmapZ(v)
+		}
+		fmt.Fprintf(w, "<text x='%g' y='%g' font-size='%g' font-family='sans-serif' text-anchor='end' "+
+			"dominant-baseline='middle' fill='black' stroke='none'>%.3g</text>",
+			bx-0.01*vw, by+(1-t)*bh, size, v)
+	}
+	fmt.Fprint(w, "</g>\n")
+}
@@ -0,0 +1,46 @@
+package surface
+
+import "bytes"
+
+// merger joins runs of consecutive cells of the same fill into a single SVG
+// path. Only consecutive cells are joined, so the painting order is kept.
+type merger struct {
+	fill    []byte // the fill of the cells in d, as appended by appendFill
+	d       []byte // the outlines of the cells of the current run
+	scratch []byte // the fill of the cell being added
+}
+
+// add adds p, colored within m, to the current run, first appending the
+// run to buf as a path if p starts a new one, and returns buf.
+func (mg *merger) add(buf []byte, m *mesh, p polygon, opts Options) []byte {
+	mg.scratch = m.appendFill(mg.scratch[:0], p, opts)
+	if !bytes.Equal(mg.scratch, mg.fill) {
+		buf = mg.flush(buf)
+		mg.fill = append(mg.fill[:0], mg.scratch...)
+	}
+	for k := 0; k < 2*p.sides(); k += 2 {
+		if k == 0 {
+			mg.d = append(mg.d, 'M')
+		} else {
+			mg.d = append(mg.d, 'L')
+		}
+		mg.d = appendCoord(mg.d, p.points[k], opts.Precision)
+		mg.d = append(mg.d, ' ')
+		mg.d = appendCoord(mg.d, p.points[k+1], opts.Precision)
+	}
+	mg.d = append(mg.d, 'Z')
+	return buf
+}
+
+// flush appends the current run, if any, to buf as a path and returns buf.
+func (mg *merger) flush(buf []byte) []byte {
+	if len(mg.d) == 0 {
+		return buf
+	}
+	buf = append(buf, "<path d='"...)
+	buf = append(buf, mg.d...)
+	buf = append(buf, "' fill='"...)
+	buf = append(buf, mg.fill...)
+	mg.d = mg.d[:0]
+	return append(buf, "/>\n"...)
+}
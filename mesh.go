@@ -0,0 +1,466 @@
+package surface
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// polygon is a projected grid cell.
+type polygon struct {
+	valid   bool       // false if any corner is NaN or Inf
+	hole    bool       // a corner was filled in by Holes "fill"
+	tri     bool       // a triangle of corners a, b, c, with d repeating c
+	layer   uint16     // index into Layers plus one, or 0 for Projector
+	z       float64    // average height of the corners
+	center  [2]float64 // average x and y of the corners, before projection
+	value   float64    // on the color ramp: z, or the slope by ColorBy
+	corners [4]float64 // heights of corners a, b, c, d
+	shade   float64    // brightness factor in [ambient, 1]
+	depth   float64    // distance of the center from the viewer
+	points  [8]float64 // projected corners a, b, c, d as x, y pairs
+}
+
+// sides returns the number of corners of p.
+func (p *polygon) sides() int {
+	if p.tri {
+		return 3
+	}
+	return 4
+}
+
+// bounds tracks the range of heights, of the values on the color ramp and
+// of projected canvas coordinates.
+type bounds struct {
+	zmin, zmax                 float64
+	vmin, vmax                 float64
+	sxmin, sxmax, symin, symax float64
+}
+
+func emptyBounds() bounds {
+	return bounds{
+		zmin: math.Inf(1), zmax: math.Inf(-1),
+		vmin: math.Inf(1), vmax: math.Inf(-1),
+		sxmin: math.Inf(1), sxmax: math.Inf(-1),
+		symin: math.Inf(1), symax: math.Inf(-1),
+	}
+}
+
+// union widens b to include o.
+func (b *bounds) union(o bounds) {
+	b.zmin, b.zmax = min(b.zmin, o.zmin), max(b.zmax, o.zmax)
+	b.vmin, b.vmax = min(b.vmin, o.vmin), max(b.vmax, o.vmax)
+	b.sxmin, b.sxmax = min(b.sxmin, o.sxmin), max(b.sxmax, o.sxmax)
+	b.symin, b.symax = min(b.symin, o.symin), max(b.symax, o.symax)
+}
+
+// mesh is the sampled and projected surface.
+type mesh struct {
+	polygons [][]polygon // cells×cells, indexed [i][j], then those of each layer
+	order    [][2]int    // indices of the valid polygons, back to front
+	bounds
+}
+
+// newMesh returns an empty mesh of nx rows of ny faces.
+func newMesh(nx, ny int) *mesh {
+	m := &mesh{polygons: make([][]polygon, nx), bounds: emptyBounds()}
+	backing := make([]polygon, nx*ny)
+	for i := range m.polygons {
+		m.polygons[i] = backing[i*ny : (i+1)*ny : (i+1)*ny]
+	}
+	return m
+}
+
+// faces returns the number of polygons of each cell of o.
+func (o Options) faces() int {
+	if o.Mesh == "tri" {
+		return 2
+	}
+	return 1
+}
+
+// meshBytes returns the memory needed to sample a mesh of nx×ny faces.
+func meshBytes(nx, ny int) int64 {
+	return int64(nx) * int64(ny) * int64(unsafe.Sizeof(polygon{}))
+}
+
+// sample computes and projects every cell of the surface and of its
+// layers, and sorts them by depth.
+func sample(ctx context.Context, opts Options) (*mesh, error) {
+	m, err := sampleGrid(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sampleLayers(ctx, opts, m); err != nil {
+		return nil, err
+	}
+	end := opts.trace("sort")
+	m.sortByDepth()
+	if opts.Cull {
+		m.cull(opts)
+	}
+	end()
+	var values []float64
+	if opts.Percentiles != ([2]float64{}) {
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				if p.valid {
+					values = append(values, p.value)
+				}
+			}
+		}
+	}
+	m.vmin, m.vmax = opts.colorRange(m.vmin, m.vmax, values)
+	if opts.Stats != nil {
+		opts.Stats.ZMin, opts.Stats.ZMax = m.zmin, m.zmax
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				if p.valid {
+					opts.Stats.Polygons++
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// sampleGrid computes and projects every cell of the surface, or the cells
+// of an adaptive quadtree over them.
+func sampleGrid(ctx context.Context, opts Options) (*mesh, error) {
+	if opts.Adaptive > 0 {
+		return sampleAdaptive(ctx, opts)
+	}
+	m := newMesh(opts.Cells, opts.faces()*opts.cellsY())
+	b, err := sweep(ctx, opts, func(i, j int, p polygon) { m.polygons[i][j] = p })
+	if err != nil {
+		return nil, err
+	}
+	m.bounds = b
+	return m, nil
+}
+
+// sweep computes and projects every cell of the surface, passing each to
+// visit, if not nil, and returns the bounds of them all. With Mesh "tri",
+// cell (i,j) is visited as its two triangles, faces (i,2j) and (i,2j+1).
+// A pool of GOMAXPROCS goroutines takes chunks of rows in turn until none
+// are left, so that workers given cheap rows take more of them; each calls
+// visit for the rows of its own chunks only.
+func sweep(ctx context.Context, opts Options, visit func(i, j int, p polygon)) (bounds, error) {
+	defer opts.trace("sample")()
+	sin, cos := math.Sincos(opts.Rotate * math.Pi / 180)
+	g, pr := opts.grid(), opts.projection()
+
+	rows, cols := opts.Cells, opts.cellsY()
+	workers := min(runtime.GOMAXPROCS(0), rows)
+	chunk := max(rows/(4*workers), 1) // rows per chunk
+	var next atomic.Int64             // first row of the next chunk
+	partial := make([]bounds, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			b := emptyBounds()
+			for {
+				start := int(next.Add(int64(chunk))) - chunk
+				if start >= rows || ctx.Err() != nil {
+					break
+				}
+				for i := start; i < min(start+chunk, rows); i++ {
+					for j := 0; j < cols; j++ {
+						if opts.Mesh == "tri" {
+							t := cellTris(opts, g, pr, i, j, i+1, j+1, sin, cos, &b)
+							if visit != nil {
+								visit(i, 2*j, t[0])
+								visit(i, 2*j+1, t[1])
+							}
+							continue
+						}
+						p := cell(opts, g, pr, i, j, sin, cos, &b)
+						if visit != nil {
+							visit(i, j, p)
+						}
+					}
+				}
+			}
+			partial[w] = b
+		}(w)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return bounds{}, err
+	}
+
+	b := emptyBounds()
+	for _, p := range partial {
+		b.union(p)
+	}
+	return b, nil
+}
+
+// cell computes and projects cell (i,j) and widens b to include it.
+func cell(opts Options, g Grid, pr projection, i, j int, sin, cos float64, b *bounds) polygon {
+	return cellRect(opts, g, pr, i, j, i+1, j+1, sin, cos, b)
+}
+
+// cellRect is cell but for the block of cells from corner (i0,j0) to
+// corner (i1,j1).
+func cellRect(opts Options, g Grid, pr projection, i0, j0, i1, j1 int, sin, cos float64, b *bounds) polygon {
+	ij := [4][2]int{{i1, j0}, {i0, j0}, {i0, j1}, {i1, j1}}
+	var xyz [4][3]float64
+	for k, c := range ij {
+		xyz[k][0], xyz[k][1], xyz[k][2] = opts.Projector.Corner(g, c[0], c[1])
+	}
+	p := face(opts, g, pr, xyz, false, sin, cos, b)
+	p.hole = opts.filledHole(ij[:])
+	return p
+}
+
+// cellTris is cellRect but for the two triangles of the block, either side
+// of the diagonal from corner (i0,j0) to corner (i1,j1), for Mesh "tri".
+func cellTris(opts Options, g Grid, pr projection, i0, j0, i1, j1 int, sin, cos float64, b *bounds) [2]polygon {
+	ij := [4][2]int{{i1, j0}, {i0, j0}, {i0, j1}, {i1, j1}}
+	var xyz [4][3]float64
+	for k, c := range ij {
+		xyz[k][0], xyz[k][1], xyz[k][2] = opts.Projector.Corner(g, c[0], c[1])
+	}
+	// Each triangle repeats its last corner as the fourth.
+	t := [2]polygon{
+		face(opts, g, pr, [4][3]float64{xyz[0], xyz[1], xyz[3], xyz[3]}, true, sin, cos, b),
+		face(opts, g, pr, [4][3]float64{xyz[1], xyz[2], xyz[3], xyz[3]}, true, sin, cos, b),
+	}
+	t[0].hole = opts.filledHole([][2]int{ij[0], ij[1], ij[3]})
+	t[1].hole = opts.filledHole(ij[1:])
+	return t
+}
+
+// filledHole reports whether a corner at ij was filled in by Holes "fill".
+func (o Options) filledHole(ij [][2]int) bool {
+	f, ok := o.Projector.(filled)
+	if !ok || o.Holes != "fill" {
+		return false
+	}
+	for _, c := range ij {
+		if f.isHole(c[0], c[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// face projects the quad with corners xyz, as sampled, or with tri the
+// triangle of its first three, and widens b to include it.
+func face(opts Options, g Grid, pr projection, xyz [4][3]float64, tri bool, sin, cos float64, b *bounds) polygon {
+	n := 4 // corners to average
+	if tri {
+		n = 3
+	}
+	mean := func(v ...float64) float64 { return average(v[:n]...) }
+	ax, ay, az := xyz[0][0], xyz[0][1], xyz[0][2]
+	bx, by, bz := xyz[1][0], xyz[1][1], xyz[1][2]
+	cx, cy, cz := xyz[2][0], xyz[2][1], xyz[2][2]
+	dx, dy, dz := xyz[3][0], xyz[3][1], xyz[3][2]
+	center := [2]float64{mean(ax, bx, cx, dx), mean(ay, by, cy, dy)}
+	// Rotate about, and project relative to, the center of the domain, or
+	// the origin for a parametric surface.
+	if _, ok := opts.Projector.(ParametricProjector); !ok {
+		ax, bx, cx, dx = ax-g.xc, bx-g.xc, cx-g.xc, dx-g.xc
+		ay, by, cy, dy = ay-g.yc, by-g.yc, cy-g.yc, dy-g.yc
+	}
+	if opts.Rotate != 0 {
+		ax, ay = rotate(ax, ay, sin, cos)
+		bx, by = rotate(bx, by, sin, cos)
+		cx, cy = rotate(cx, cy, sin, cos)
+		dx, dy = rotate(dx, dy, sin, cos)
+	}
+	// Skip polygon if value is NaN or Inf. Its finite corners still count
+	// toward the range of heights, as those of a solid do.
+	if err := az + bz + cz + dz; math.IsNaN(err) || math.IsInf(err, 0) {
+		for _, z := range [...]float64{az, bz, cz, dz} {
+			if !math.IsNaN(z) && !math.IsInf(z, 0) {
+				z = opts.mapZ(z)
+				b.zmin, b.zmax = min(b.zmin, z), max(b.zmax, z)
+			}
+		}
+		if opts.ColorBy != "slope" {
+			b.vmin, b.vmax = b.zmin, b.zmax
+		}
+		return polygon{}
+	}
+	az, bz, cz, dz = opts.mapZ(az), opts.mapZ(bz), opts.mapZ(cz), opts.mapZ(dz)
+
+	depth := pr.depth(mean(ax, bx, cx, dx), mean(ay, by, cy, dy), mean(az, bz, cz, dz))
+	value := mean(az, bz, cz, dz)
+	if opts.ColorBy == "slope" {
+		// The gradient is the tilt of the normal, here across the diagonals,
+		// which for a triangle are two of its sides.
+		n := cross([3]float64{dx - bx, dy - by, dz - bz}, [3]float64{cx - ax, cy - ay, cz - az})
+		value = math.Hypot(n[0], n[1]) / math.Abs(n[2])
+	}
+	brightness := 1.0
+	if opts.Shading {
+		brightness = lambert(pr,
+			[3]float64{ax, ay, az}, [3]float64{bx, by, bz},
+			[3]float64{cx, cy, cz}, [3]float64{dx, dy, dz})
+	}
+
+	ax, ay = pr.project(ax, ay, az)
+	bx, by = pr.project(bx, by, bz)
+	cx, cy = pr.project(cx, cy, cz)
+	dx, dy = pr.project(dx, dy, dz)
+	if math.IsNaN(ax + bx + cx + dx) {
+		return polygon{} // behind a perspective camera
+	}
+
+	b.zmax = max(b.zmax, az, bz, cz, dz)
+	b.zmin = min(b.zmin, az, bz, cz, dz)
+	// The ramp spans the heights of the corners, or the slopes of the cells.
+	if opts.ColorBy == "slope" {
+		b.vmax, b.vmin = max(b.vmax, value), min(b.vmin, value)
+	} else {
+		b.vmax, b.vmin = b.zmax, b.zmin
+	}
+	b.sxmax = max(b.sxmax, ax, bx, cx, dx)
+	b.sxmin = min(b.sxmin, ax, bx, cx, dx)
+	b.symax = max(b.symax, ay, by, cy, dy)
+	b.symin = min(b.symin, ay, by, cy, dy)
+
+	return polygon{
+		valid:   true,
+		tri:     tri,
+		z:       mean(az, bz, cz, dz),
+		center:  center,
+		value:   value,
+		corners: [4]float64{az, bz, cz, dz},
+		shade:   brightness,
+		depth:   depth,
+		points:  [8]float64{ax, ay, bx, by, cx, cy, dx, dy},
+	}
+}
+
+// viewBox returns an SVG viewBox enclosing the projected surface with a
+// margin on every side, or the whole width×height canvas if nothing was
+// projected. The margin is padding pixels once the viewBox is scaled to fit
+// the canvas, or if padding is zero 2% of the larger extent of the surface.
+func (b bounds) viewBox(width, height int, padding float64) string {
+	x, y, w, h := b.viewRect(width, height, padding)
+	return fmt.Sprintf("%g %g %g %g", x, y, w, h)
+}
+
+// viewRect returns the origin and size of the viewBox of b.
+func (b bounds) viewRect(width, height int, padding float64) (x, y, w, h float64) {
+	if b.sxmax < b.sxmin {
+		return 0, 0, float64(width), float64(height)
+	}
+	w, h = b.sxmax-b.sxmin, b.symax-b.symin
+	margin := 0.02 * max(w, h)
+	if padding > 0 {
+		// The scale at which the surface fills the canvas less the padding.
+		s := min((float64(width)-2*padding)/w, (float64(height)-2*padding)/h)
+		margin = padding / s
+	}
+	return b.sxmin - margin, b.symin - margin, w + 2*margin, h + 2*margin
+}
+
+// clamp limits z to [-limit, limit].
+func clamp(z, limit float64) float64 {
+	return min(max(z, -limit), limit)
+}
+
+// colorRange returns the range of the color ramp over values, which span
+// vmin..vmax: ColorMin..ColorMax of o if not empty, or else the
+// Percentiles of the finite values, if set, or else vmin..vmax.
+func (o Options) colorRange(vmin, vmax float64, values []float64) (float64, float64) {
+	if o.ColorMin < o.ColorMax {
+		if o.ColorBy == "slope" {
+			return o.ColorMin, o.ColorMax
+		}
+		return o.mapZ(o.ColorMin), o.mapZ(o.ColorMax)
+	}
+	if o.Percentiles == ([2]float64{}) {
+		return vmin, vmax
+	}
+	var sorted []float64
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			sorted = append(sorted, v)
+		}
+	}
+	if len(sorted) == 0 {
+		return vmin, vmax
+	}
+	slices.Sort(sorted)
+	return percentile(sorted, o.Percentiles[0]), percentile(sorted, o.Percentiles[1])
+}
+
+// percentile interpolates the pth percentile of sorted, which is not empty.
+func percentile(sorted []float64, p float64) float64 {
+	x := p / 100 * float64(len(sorted)-1)
+	k := min(int(x), len(sorted)-2)
+	if k < 0 {
+		return sorted[0]
+	}
+	return sorted[k] + (x-float64(k))*(sorted[k+1]-sorted[k])
+}
+
+// mapZ returns the height z clamped by ZClamp and ZClampMin..ZClampMax of
+// o, then transformed by its ZMap.
+func (o Options) mapZ(z float64) float64 {
+	if o.ZClamp > 0 {
+		z = clamp(z, o.ZClamp)
+	}
+	if o.ZClampMin < o.ZClampMax {
+		z = min(max(z, o.ZClampMin), o.ZClampMax)
+	}
+	switch o.ZMap {
+	case "log":
+		return math.Copysign(math.Log1p(math.Abs(z)), z)
+	case "sqrt":
+		return math.Copysign(math.Sqrt(math.Abs(z)), z)
+	}
+	return z
+}
+
+// unmapZ undoes the ZMap of o, for labelling mapped heights with the
+// heights of the function.
+func (o Options) unmapZ(z float64) float64 {
+	switch o.ZMap {
+	case "log":
+		return math.Copysign(math.Expm1(math.Abs(z)), z)
+	case "sqrt":
+		return math.Copysign(z*z, z)
+	}
+	return z
+}
+
+// sortByDepth fills m.order with the valid polygons from the furthest to
+// the nearest, so that drawing them in that order lets nearer cells cover
+// those behind them. Cells at equal depth keep their grid order.
+func (m *mesh) sortByDepth() {
+	m.order = m.order[:0]
+	for i := range m.polygons {
+		for j := range m.polygons[i] {
+			if m.polygons[i][j].valid {
+				m.order = append(m.order, [2]int{i, j})
+			}
+		}
+	}
+	slices.SortStableFunc(m.order, func(a, b [2]int) int {
+		return cmp.Compare(m.polygons[b[0]][b[1]].depth, m.polygons[a[0]][a[1]].depth)
+	})
+}
+
+// checkpoint returns the error of ctx once for every row's worth of cells
+// drawn, so that writers stop soon after a request is cancelled.
+func (m *mesh) checkpoint(ctx context.Context, n int) error {
+	if n%len(m.polygons) != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,92 @@
+package surface
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+)
+
+// serialSweep is sweep computed in grid order on the calling goroutine.
+func serialSweep(opts Options) ([][]polygon, bounds) {
+	sin, cos := math.Sincos(opts.Rotate * math.Pi / 180)
+	g, pr := opts.grid(), opts.projection()
+	m := newMesh(opts.Cells, opts.faces()*opts.cellsY())
+	b := emptyBounds()
+	for i := 0; i < opts.Cells; i++ {
+		for j := 0; j < opts.cellsY(); j++ {
+			if opts.Mesh == "tri" {
+				t := cellTris(opts, g, pr, i, j, i+1, j+1, sin, cos, &b)
+				m.polygons[i][2*j], m.polygons[i][2*j+1] = t[0], t[1]
+				continue
+			}
+			m.polygons[i][j] = cell(opts, g, pr, i, j, sin, cos, &b)
+		}
+	}
+	return m.polygons, b
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	saddle, _ := LookupProjector("saddle")
+	for name, opts := range map[string]Options{
+		"sin":    {Cells: 64},
+		"saddle": {Projector: saddle, Cells: 50, CellsY: 30, Rotate: 30, Shading: true},
+		"tri":    {Projector: saddle, Cells: 40, Mesh: "tri"},
+	} {
+		opts = opts.withDefaults()
+		want, wantBounds := serialSweep(opts)
+		got := newMesh(opts.Cells, opts.faces()*opts.cellsY())
+		b, err := sweep(context.Background(), opts, func(i, j int, p polygon) { got.polygons[i][j] = p })
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if b != wantBounds {
+			t.Errorf("%s: bounds %+v, want %+v", name, b, wantBounds)
+		}
+		for i := range want {
+			for j, p := range want[i] {
+				// Cells with a NaN corner hold NaNs, which equal nothing.
+				if q := got.polygons[i][j]; q != p && (p.valid || q.valid) {
+					t.Fatalf("%s: cell (%d,%d) is %+v, want %+v", name, i, j, q, p)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSweep(b *testing.B) {
+	opts := Options{Cells: 500}.withDefaults()
+	for _, bm := range []struct {
+		name  string
+		procs int
+	}{{"serial", 1}, {"parallel", runtime.NumCPU()}} {
+		b.Run(bm.name, func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(bm.procs))
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := sweep(context.Background(), opts, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSample measures the speedup of the worker pool of sweep with the
+// number of workers, sampling a whole mesh of 500×500 cells.
+func BenchmarkSample(b *testing.B) {
+	opts := Options{Cells: 500}.withDefaults()
+	for _, procs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := sample(context.Background(), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
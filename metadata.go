@@ -0,0 +1,61 @@
+package surface
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Metadata describes where an SVG comes from, written into it as Dublin
+// Core metadata.
+type Metadata struct {
+	Function   string    // name or expression of the surface
+	Parameters string    // query of the render
+	Created    time.Time // zero leaves out the date, for renders to be reproducible
+}
+
+// svgMetadata writes the title, description and metadata of opts, which
+// follow the opening <svg> tag.
+func svgMetadata(w io.Writer, opts Options) {
+	if opts.Title != "" {
+		fmt.Fprintf(w, "<title>%s</title>", xmlText(opts.Title))
+	}
+	if opts.Desc != "" {
+		fmt.Fprintf(w, "<desc>%s</desc>", xmlText(opts.Desc))
+	}
+	m := opts.Metadata
+	if m == nil {
+		if opts.Title != "" || opts.Desc != "" {
+			fmt.Fprintln(w)
+		}
+		return
+	}
+	fmt.Fprint(w, "<metadata><rdf:RDF xmlns:rdf='http://www.w3.org/1999/02/22-rdf-syntax-ns#' "+
+		"xmlns:dc='http://purl.org/dc/elements/1.1/'><rdf:Description>")
+	var date string
+	if !m.Created.IsZero() {
+		date = m.Created.UTC().Format(time.RFC3339)
+	}
+	for _, e := range [][2]string{
+		{"title", opts.Title},
+		{"description", opts.Desc},
+		{"subject", m.Function},
+		{"source", m.Parameters},
+		{"date", date},
+		{"format", "image/svg+xml"},
+	} {
+		if e[1] != "" {
+			fmt.Fprintf(w, "<dc:%s>%s</dc:%[1]s>", e[0], xmlText(e[1]))
+		}
+	}
+	fmt.Fprintln(w, "</rdf:Description></rdf:RDF></metadata>")
+}
+
+// xmlText returns s escaped as the text of an XML element.
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
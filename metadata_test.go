@@ -0,0 +1,65 @@
+package surface
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetadataReproducible(t *testing.T) {
+	// Without a cache, each request renders again.
+	h := NewHandler(HandlerConfig{})
+	first := get(t, h, "/?cells=10&title=A%20%3Cb%3E")
+	second := get(t, h, "/?cells=10&title=A%20%3Cb%3E")
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Fatal("two renders of the same parameters differ")
+	}
+	body := first.Body.String()
+	for _, want := range []string{"role='img'", "<title>A &lt;b&gt;</title>", "<dc:subject>sin</dc:subject>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the svg lacks %s", want)
+		}
+	}
+	if strings.Contains(body, "<dc:date>") {
+		t.Error("the svg has a date without 'created'")
+	}
+	w := get(t, h, "/?cells=10&title=A%20%3Cb%3E", "If-None-Match", first.Header().Get("ETag"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match of a render again: status %d, want 304", w.Code)
+	}
+}
+
+func TestMetadataCreated(t *testing.T) {
+	h := NewHandler(HandlerConfig{})
+	w := get(t, h, "/?cells=10&created=2026-10-14T11:00:00%2B02:00")
+	if want := "<dc:date>2026-10-14T09:00:00Z</dc:date>"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("the svg lacks %s", want)
+	}
+	if _, _, err := ParseQuery(mustQuery(t, "created=yesterday")); err == nil {
+		t.Error("created=yesterday parsed")
+	}
+}
+
+func TestMetadataNormalizedQuery(t *testing.T) {
+	// Equivalent spellings draw the same SVG, whether rendered or cached.
+	targets := []string{"/?cells=5&rotate=0.0", "/?cells=5&rotate=0"}
+	var bodies [][]byte
+	var etags []string
+	for _, cacheBytes := range []int{0, 64 << 20} {
+		h := NewHandler(HandlerConfig{CacheBytes: cacheBytes})
+		for _, target := range targets {
+			w := get(t, h, target)
+			bodies = append(bodies, w.Body.Bytes())
+			etags = append(etags, w.Header().Get("ETag"))
+		}
+	}
+	for k := 1; k < len(bodies); k++ {
+		if !bytes.Equal(bodies[k], bodies[0]) || etags[k] != etags[0] {
+			t.Errorf("response %d differs from the first", k)
+		}
+	}
+	if want := "<dc:source>cells=5&amp;rotate=0</dc:source>"; !bytes.Contains(bodies[0], []byte(want)) {
+		t.Errorf("the svg lacks %s", want)
+	}
+}
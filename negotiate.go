@@ -0,0 +1,55 @@
+package surface
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// formatTypes are the formats of the media types a request may accept.
+var formatTypes = map[string]string{
+	"image/svg+xml":     "svg",
+	"application/json":  "json",
+	"image/png":         "png",
+	"image/gif":         "gif",
+	"application/pdf":   "pdf",
+	"model/obj":         "obj",
+	"model/stl":         "stl",
+	"model/gltf-binary": "gltf",
+}
+
+// negotiateFormat returns the format of the media type accept prefers,
+// by quality and then order, or "" if it prefers none, as when it is empty
+// or accepts any type. It reports false if accept lists no type there is a
+// format for.
+func negotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "", true
+	}
+	format, best, wildcard := "", 0.0, false
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		f, ok := formatTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "image/*" {
+			f, wildcard = "", true
+		} else if !ok {
+			continue
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	return format, format != "" || wildcard
+}
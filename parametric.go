@@ -0,0 +1,85 @@
+package surface
+
+import "math"
+
+// ParametricProjector is implemented by projectors of parametric surfaces,
+// whose points are functions of two parameters (u,v) rather than heights
+// over the xy plane. The grid of a render spans Domain, the ranges of u and
+// v, instead of the x and y domain of the options, so Corner finds (u,v)
+// with g.Corner. Extent is the radius of a sphere about the origin that
+// holds the surface, which is drawn to scale along all three axes and
+// fitted to the canvas by it.
+type ParametricProjector interface {
+	Projector
+	Domain() (umin, umax, vmin, vmax float64)
+	Extent() float64
+}
+
+// TorusProjector is a ring torus about the z axis.
+type TorusProjector struct{}
+
+const (
+	torusMajor = 2.0 // distance from the z axis to the center of the tube
+	torusMinor = 0.8 // radius of the tube
+)
+
+func (TorusProjector) Description() string { return "a doughnut-shaped ring torus" }
+
+func (TorusProjector) Domain() (float64, float64, float64, float64) {
+	return 0, 2 * math.Pi, 0, 2 * math.Pi
+}
+
+func (TorusProjector) Extent() float64 { return torusMajor + torusMinor }
+
+func (TorusProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	u, v := g.Corner(i, j) // angles about the z axis and the tube
+	sinV, cosV := math.Sincos(v)
+	r := torusMajor + torusMinor*cosV
+	sinU, cosU := math.Sincos(u)
+	return r * cosU, r * sinU, torusMinor * sinV
+}
+
+// SphereProjector is a sphere about the origin.
+type SphereProjector struct{}
+
+const sphereRadius = 2.0
+
+func (SphereProjector) Description() string { return "a sphere" }
+
+func (SphereProjector) Domain() (float64, float64, float64, float64) {
+	return 0, 2 * math.Pi, -math.Pi / 2, math.Pi / 2
+}
+
+func (SphereProjector) Extent() float64 { return sphereRadius }
+
+func (SphereProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	u, v := g.Corner(i, j) // longitude and latitude
+	sinV, cosV := math.Sincos(v)
+	sinU, cosU := math.Sincos(u)
+	return sphereRadius * cosV * cosU, sphereRadius * cosV * sinU, sphereRadius * sinV
+}
+
+// MobiusProjector is a Möbius strip: a band with a half twist, which has a
+// single side.
+type MobiusProjector struct{}
+
+const (
+	mobiusRadius = 2.0 // from the z axis to the middle of the band
+	mobiusWidth  = 1.5
+)
+
+func (MobiusProjector) Description() string { return "a Möbius strip, a band with a half twist" }
+
+func (MobiusProjector) Domain() (float64, float64, float64, float64) {
+	return 0, 2 * math.Pi, -mobiusWidth / 2, mobiusWidth / 2
+}
+
+func (MobiusProjector) Extent() float64 { return math.Hypot(mobiusRadius+mobiusWidth/2, mobiusWidth/2) }
+
+func (MobiusProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	u, v := g.Corner(i, j) // angle about the z axis and offset across the band
+	sinHalf, cosHalf := math.Sincos(u / 2)
+	r := mobiusRadius + v*cosHalf
+	sinU, cosU := math.Sincos(u)
+	return r * cosU, r * sinU, v * sinHalf
+}
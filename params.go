@@ -0,0 +1,950 @@
+package surface
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minCanvas = 50 // least width and height in pixels
+
+// MaxCanvas is the largest width and height in pixels, and MaxCells the
+// most grid cells per side, that ParseQuery accepts.
+const MaxCanvas, MaxCells = 4000, 1000
+
+const maxBands = 256 // most color bands a request may ask for
+
+const maxScale = 100000 // most pixels per unit of scale and zscale
+
+// pageSizes are the named PDF page sizes, in portrait, in points.
+var pageSizes = map[string][2]float64{
+	"a3":     {842, 1191},
+	"a4":     {595, 842},
+	"a5":     {420, 595},
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+}
+
+// ParamError is an invalid query parameter, or with an empty Param another
+// error of a request.
+type ParamError struct {
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ParamErrors are the invalid parameters of a query.
+type ParamErrors []ParamError
+
+func (e *ParamErrors) add(param string, err error) {
+	*e = append(*e, ParamError{Param: param, Message: err.Error()})
+}
+
+func (e ParamErrors) Error() string {
+	messages := make([]string, len(e))
+	for k, pe := range e {
+		messages[k] = pe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ParseQuery converts the query parameters q of a render into options and
+// the normalized name of the requested function. For function=heightmap the
+// projector is left nil: the heights come from the request body or a file,
+// which the caller reads. Every invalid parameter is reported, in a
+// ParamErrors.
+func ParseQuery(q url.Values) (Options, string, error) {
+	var err error
+	var errs ParamErrors
+	opts := DefaultOptions()
+	function := "sin"
+	tunings, terrs := parseTunings(q)
+	errs = append(errs, terrs...)
+	lookup := func(name string) (Projector, bool) {
+		p, ok := LookupProjector(name)
+		if t, tunable := p.(Tunable); tunable && tunings[name] != nil {
+			p = t.Tune(tunings[name])
+		}
+		return p, ok
+	}
+	peakColor := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	valleyColor := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	functions := listValues(q, "function")
+	projectorStr := q.Get("function")
+	if len(functions) > 1 {
+		projectorStr = functions[0]
+	}
+	if projectorStr != "" {
+		function = strings.ToLower(strings.TrimSpace(projectorStr))
+		if function == "heightmap" {
+			opts.Projector = nil // read from the request body by the caller
+		} else if p, ok := lookup(function); ok {
+			opts.Projector = p
+		} else if isComposite(function) {
+			opts.Projector, _, err = parseComposite(function, lookup)
+			if err != nil {
+				errs.add("function", err)
+			}
+			function = "composite"
+		} else {
+			function = "unknown" // keep arbitrary input out of the metric labels
+			errs.add("function", fmt.Errorf("unknown value 'function'=%q", projectorStr))
+		}
+	}
+	if exprStr := q.Get("expr"); exprStr != "" {
+		if q.Has("function") {
+			errs.add("function", fmt.Errorf("set either 'function' or 'expr', not both"))
+		}
+		function = "expr"
+		opts.Projector, err = NewExprProjector(exprStr)
+		if err != nil {
+			errs.add("expr", err)
+		}
+	}
+	if q.Has("seed") || q.Has("octaves") || q.Has("roughness") {
+		var seed int64
+		octaves, roughness := defaultOctaves, defaultRoughness
+		if seedStr := q.Get("seed"); seedStr != "" {
+			seed, err = strconv.ParseInt(seedStr, 10, 64)
+			if err != nil {
+				errs.add("seed", fmt.Errorf("cannot parse 'seed' %q to an integer", seedStr))
+			}
+		}
+		if octavesStr := q.Get("octaves"); octavesStr != "" {
+			octaves, err = strconv.Atoi(octavesStr)
+			if err != nil || octaves < 1 || octaves > maxOctaves {
+				errs.add("octaves", fmt.Errorf("cannot parse 'octaves' %q to an integer in 1..%d", octavesStr, maxOctaves))
+			}
+		}
+		if roughnessStr := q.Get("roughness"); roughnessStr != "" {
+			roughness, err = strconv.ParseFloat(roughnessStr, 64)
+			if err != nil || !(roughness > 0 && roughness <= 1) {
+				errs.add("roughness", fmt.Errorf("cannot parse 'roughness' %q to a factor in (0, 1]", roughnessStr))
+			}
+		}
+		if _, ok := opts.Projector.(TerrainProjector); ok {
+			opts.Projector = NewTerrainProjector(seed, octaves, roughness)
+		}
+	}
+	if q.Has("c") || q.Has("iterations") || q.Has("fractalzoom") {
+		c := defaultJulia
+		iterations, zoom := defaultIterations, 1.0
+		if cStr := q.Get("c"); cStr != "" {
+			c, err = parseComplex(cStr)
+			if err != nil {
+				errs.add("c", fmt.Errorf("cannot parse 'c' %q to a complex number re,im", cStr))
+			}
+		}
+		if iterationsStr := q.Get("iterations"); iterationsStr != "" {
+			iterations, err = strconv.Atoi(iterationsStr)
+			if err != nil || iterations < 1 || iterations > maxIterations {
+				errs.add("iterations", fmt.Errorf("cannot parse 'iterations' %q to an integer in 1..%d", iterationsStr, maxIterations))
+			}
+		}
+		if zoomStr := q.Get("fractalzoom"); zoomStr != "" {
+			zoom, err = strconv.ParseFloat(zoomStr, 64)
+			if err != nil || !(zoom > 0) || math.IsInf(zoom, 0) {
+				errs.add("fractalzoom", fmt.Errorf("cannot parse 'fractalzoom' %q to a positive factor", zoomStr))
+			}
+		}
+		if f, ok := opts.Projector.(FractalProjector); ok {
+			if f.julia {
+				opts.Projector = NewJuliaProjector(c, iterations, zoom)
+			} else {
+				opts.Projector = NewMandelbrotProjector(iterations, zoom)
+			}
+		}
+	}
+	if q.Has("l") || q.Has("m") {
+		l, m := defaultDegree, defaultOrder
+		if lStr := q.Get("l"); lStr != "" {
+			l, err = strconv.Atoi(lStr)
+			if err != nil || l < 0 || l > maxDegree {
+				errs.add("l", fmt.Errorf("cannot parse 'l' %q to an integer in 0..%d", lStr, maxDegree))
+			}
+		}
+		if mStr := q.Get("m"); mStr != "" {
+			m, err = strconv.Atoi(mStr)
+			if err != nil || m < -l || m > l {
+				errs.add("m", fmt.Errorf("cannot parse 'm' %q to an integer in -l..l", mStr))
+			}
+		} else {
+			m = min(m, l)
+		}
+		if _, ok := opts.Projector.(HarmonicProjector); ok {
+			opts.Projector = NewHarmonicProjector(l, m)
+		}
+	}
+	if heightStr := q.Get("height"); heightStr != "" {
+		opts.Height, err = strconv.Atoi(heightStr)
+		if err != nil || opts.Height < minCanvas || opts.Height > MaxCanvas {
+			errs.add("height", fmt.Errorf("cannot parse 'height' %q to an integer in %d..%d", heightStr, minCanvas, MaxCanvas))
+		}
+	}
+	if widthStr := q.Get("width"); widthStr != "" {
+		opts.Width, err = strconv.Atoi(widthStr)
+		if err != nil || opts.Width < minCanvas || opts.Width > MaxCanvas {
+			errs.add("width", fmt.Errorf("cannot parse 'width' %q to an integer in %d..%d", widthStr, minCanvas, MaxCanvas))
+		}
+	}
+	if colorStr := q.Get("valley"); colorStr != "" {
+		valleyColor, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("valley", fmt.Errorf("cannot parse 'valley': %v", err))
+		}
+	}
+	if colorStr := q.Get("peak"); colorStr != "" {
+		peakColor, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("peak", fmt.Errorf("cannot parse 'peak': %v", err))
+		}
+	}
+	opts.Stops = []color.RGBA{valleyColor, peakColor}
+	if stopsStr := q.Get("stops"); stopsStr != "" {
+		opts.Stops, opts.Offsets, err = parseStops("stops", stopsStr)
+		if err != nil {
+			errs.add("stops", err)
+		}
+	}
+	if colorsStr := q.Get("colors"); colorsStr != "" {
+		if q.Has("stops") {
+			errs.add("colors", fmt.Errorf("set either 'colors' or 'stops', not both"))
+		}
+		opts.Stops, opts.Offsets, err = parseStops("colors", colorsStr)
+		if err != nil {
+			errs.add("colors", err)
+		}
+	}
+	if colormapStr := q.Get("colormap"); colormapStr != "" {
+		colormapStr = listValues(q, "colormap")[0]
+		if q.Has("stops") || q.Has("colors") {
+			errs.add("colormap", fmt.Errorf("set only one of 'colormap', 'stops' and 'colors'"))
+		}
+		stops, ok := Colormap(strings.ToLower(strings.TrimSpace(colormapStr)))
+		if !ok {
+			errs.add("colormap", fmt.Errorf("unknown value 'colormap'=%q, want one of %s", colormapStr, strings.Join(Colormaps(), ", ")))
+		}
+		opts.Stops = stops
+	}
+	if divergingStr := q.Get("diverging"); divergingStr != "" {
+		opts.Diverging, err = strconv.ParseBool(divergingStr)
+		if err != nil {
+			errs.add("diverging", fmt.Errorf("cannot parse 'diverging' %q to bool", divergingStr))
+		}
+		colored := q.Has("peak") || q.Has("valley") || q.Has("stops") || q.Has("colors") || q.Has("colormap")
+		if opts.Diverging && !colored {
+			opts.Stops, _ = Colormap("coolwarm")
+		}
+	}
+	if centerStr := q.Get("center"); centerStr != "" {
+		opts.Center, err = strconv.ParseFloat(centerStr, 64)
+		if err != nil || math.IsNaN(opts.Center) || math.IsInf(opts.Center, 0) {
+			errs.add("center", fmt.Errorf("cannot parse 'center' %q to a height", centerStr))
+		}
+	}
+	if bandsStr := q.Get("bands"); bandsStr != "" {
+		opts.Bands, err = strconv.Atoi(bandsStr)
+		if err != nil || opts.Bands < 0 || opts.Bands > maxBands {
+			errs.add("bands", fmt.Errorf("cannot parse 'bands' %q to an integer in 0..%d", bandsStr, maxBands))
+		}
+	}
+	var force bool
+	if forceStr := q.Get("force"); forceStr != "" {
+		force, err = strconv.ParseBool(forceStr)
+		if err != nil {
+			errs.add("force", fmt.Errorf("cannot parse 'force' %q to bool", forceStr))
+		}
+	}
+	// Equal colors flatten the gradient to a single tone, which is almost
+	// always a typo when the user picked at least one of them.
+	explicit := q.Has("peak") || q.Has("valley")
+	if explicit && peakColor == valleyColor && !force {
+		errs.add("peak", fmt.Errorf("'peak' and 'valley' are the same color so the gradient would be invisible; set 'force'=true to render anyway"))
+	}
+	if rotateStr := q.Get("rotate"); rotateStr != "" {
+		opts.Rotate, err = strconv.ParseFloat(rotateStr, 64)
+		if err != nil || math.IsNaN(opts.Rotate) || math.IsInf(opts.Rotate, 0) {
+			errs.add("rotate", fmt.Errorf("cannot parse 'rotate' %q to degrees", rotateStr))
+		}
+		opts.Rotate = math.Mod(opts.Rotate, 360)
+	}
+	if azimuthStr := q.Get("azimuth"); azimuthStr != "" {
+		opts.Azimuth, err = strconv.ParseFloat(azimuthStr, 64)
+		if err != nil || math.IsNaN(opts.Azimuth) || math.IsInf(opts.Azimuth, 0) {
+			errs.add("azimuth", fmt.Errorf("cannot parse 'azimuth' %q to degrees", azimuthStr))
+		}
+		opts.Azimuth = math.Mod(opts.Azimuth, 360)
+	}
+	if elevationStr := q.Get("elevation"); elevationStr != "" {
+		opts.Elevation, err = strconv.ParseFloat(elevationStr, 64)
+		if err != nil || !(opts.Elevation > 0 && opts.Elevation <= 90) {
+			errs.add("elevation", fmt.Errorf("cannot parse 'elevation' %q to degrees in (0, 90]", elevationStr))
+		}
+	}
+	if zoomStr := q.Get("zoom"); zoomStr != "" {
+		opts.Zoom, err = strconv.ParseFloat(zoomStr, 64)
+		if err != nil || !(opts.Zoom > 0) || opts.Zoom > 100 {
+			errs.add("zoom", fmt.Errorf("cannot parse 'zoom' %q to a factor in (0, 100]", zoomStr))
+		}
+	}
+	switch opts.View = q.Get("view"); opts.View {
+	case "", "surface", "heatmap", "slice", "stereo", "anaglyph":
+	default:
+		errs.add("view", fmt.Errorf("unknown value 'view'=%q", opts.View))
+	}
+	switch opts.Projection = q.Get("projection"); opts.Projection {
+	case "", "orthographic", "perspective":
+	default:
+		errs.add("projection", fmt.Errorf("unknown value 'projection'=%q", opts.Projection))
+	}
+	if fovStr := q.Get("fov"); fovStr != "" {
+		opts.FOV, err = strconv.ParseFloat(fovStr, 64)
+		if err != nil || !(opts.FOV >= 1 && opts.FOV <= 170) {
+			errs.add("fov", fmt.Errorf("cannot parse 'fov' %q to degrees in 1..170", fovStr))
+		}
+	}
+	if distanceStr := q.Get("distance"); distanceStr != "" {
+		opts.Distance, err = strconv.ParseFloat(distanceStr, 64)
+		if err != nil || !(opts.Distance > 0) || math.IsInf(opts.Distance, 0) {
+			errs.add("distance", fmt.Errorf("cannot parse 'distance' %q to a positive float", distanceStr))
+		}
+	}
+	if cellsStr := q.Get("cells"); cellsStr != "" {
+		opts.Cells, err = strconv.Atoi(cellsStr)
+		if err != nil || opts.Cells < 1 || opts.Cells > MaxCells {
+			errs.add("cells", fmt.Errorf("cannot parse 'cells' %q to an integer in 1..%d", cellsStr, MaxCells))
+		}
+	}
+	if q.Has("cellsx") || q.Has("cellsy") {
+		nx, ny := opts.Cells, opts.Cells
+		for _, p := range []struct {
+			name string
+			v    *int
+		}{{"cellsx", &nx}, {"cellsy", &ny}} {
+			s := q.Get(p.name)
+			if s == "" {
+				continue
+			}
+			*p.v, err = strconv.Atoi(s)
+			if err != nil || *p.v < 1 || *p.v > MaxCells {
+				errs.add(p.name, fmt.Errorf("cannot parse '%s' %q to an integer in 1..%d", p.name, s, MaxCells))
+			}
+		}
+		opts.Cells, opts.CellsY = nx, ny
+	}
+	if rangeStr := q.Get("range"); rangeStr != "" {
+		opts.XYRange, err = strconv.ParseFloat(rangeStr, 64)
+		if err != nil || !(opts.XYRange > 0) || math.IsInf(opts.XYRange, 0) {
+			errs.add("range", fmt.Errorf("cannot parse 'range' %q to a positive float", rangeStr))
+		}
+	}
+	for _, a := range []struct {
+		axis   string
+		lo, hi *float64
+	}{{"x", &opts.XMin, &opts.XMax}, {"y", &opts.YMin, &opts.YMax}} {
+		span := opts.XYRange
+		if s := q.Get(a.axis + "range"); s != "" {
+			span, err = strconv.ParseFloat(s, 64)
+			if err != nil || !(span > 0) || math.IsInf(span, 0) {
+				errs.add(a.axis+"range", fmt.Errorf("cannot parse '%srange' %q to a positive float", a.axis, s))
+				continue
+			}
+		}
+		if q.Has(a.axis+"range") || q.Has(a.axis+"min") || q.Has(a.axis+"max") {
+			*a.lo, *a.hi, err = parseDomain(q, a.axis, span)
+			if err != nil {
+				errs.add(a.axis+"min", err)
+			}
+		}
+	}
+	if opts.View == "slice" || q.Has("axis") || q.Has("at") {
+		if _, ok := opts.Projector.(ParametricProjector); ok {
+			errs.add("view", fmt.Errorf("'view'=slice applies to height fields, not the parametric surface %s", function))
+		}
+		xmin, xmax, ymin, ymax := opts.Domain()
+		lo, hi := ymin, ymax
+		switch opts.SliceAxis = q.Get("axis"); opts.SliceAxis {
+		case "", "y":
+		case "x":
+			lo, hi = xmin, xmax
+		default:
+			errs.add("axis", fmt.Errorf("unknown value 'axis'=%q, want x or y", opts.SliceAxis))
+		}
+		opts.SliceAt = (lo + hi) / 2
+		if atStr := q.Get("at"); atStr != "" {
+			opts.SliceAt, err = strconv.ParseFloat(atStr, 64)
+			if err != nil || !(opts.SliceAt >= lo && opts.SliceAt <= hi) {
+				errs.add("at", fmt.Errorf("cannot parse 'at' %q to a value of %s in %g..%g", atStr, opts.sliceAxis(), lo, hi))
+			}
+		}
+	}
+	if animateStr := q.Get("animate"); animateStr != "" {
+		opts.Animate, err = strconv.ParseBool(animateStr)
+		if err != nil {
+			errs.add("animate", fmt.Errorf("cannot parse 'animate' %q to bool", animateStr))
+		}
+	}
+	if tStr := q.Get("t"); tStr != "" {
+		if opts.Animate {
+			errs.add("t", fmt.Errorf("set either 't' or 'animate', not both"))
+		}
+		opts.Time, err = strconv.ParseFloat(tStr, 64)
+		if err != nil || math.IsNaN(opts.Time) || math.IsInf(opts.Time, 0) {
+			errs.add("t", fmt.Errorf("cannot parse 't' %q to a float", tStr))
+		}
+		if opts.Time -= math.Floor(opts.Time); opts.Time >= 1 { // t loops
+			opts.Time = 0
+		}
+	}
+	if durationStr := q.Get("duration"); durationStr != "" {
+		opts.Duration, err = time.ParseDuration(durationStr)
+		if err != nil || opts.Duration <= 0 {
+			errs.add("duration", fmt.Errorf("cannot parse 'duration' %q to a positive duration", durationStr))
+		}
+	}
+	if framesStr := q.Get("frames"); framesStr != "" {
+		opts.Frames, err = strconv.Atoi(framesStr)
+		if err != nil || opts.Frames < 2 || opts.Frames > 360 {
+			errs.add("frames", fmt.Errorf("cannot parse 'frames' %q to an integer in 2..360", framesStr))
+		}
+	}
+	if precisionStr := q.Get("precision"); precisionStr != "" {
+		opts.Precision, err = strconv.Atoi(precisionStr)
+		if err != nil || opts.Precision < 0 || opts.Precision > 10 {
+			errs.add("precision", fmt.Errorf("cannot parse 'precision' %q to an integer in 0..10", precisionStr))
+		}
+	}
+	if flipyStr := q.Get("flipy"); flipyStr != "" {
+		opts.FlipY, err = strconv.ParseBool(flipyStr)
+		if err != nil {
+			errs.add("flipy", fmt.Errorf("cannot parse 'flipy' %q to bool", flipyStr))
+		}
+	}
+	if zclampStr := q.Get("zclamp"); zclampStr != "" {
+		if lo, hi, ok := strings.Cut(zclampStr, ","); ok {
+			var err1, err2 error
+			opts.ZClampMin, err1 = strconv.ParseFloat(lo, 64)
+			opts.ZClampMax, err2 = strconv.ParseFloat(hi, 64)
+			if err1 != nil || err2 != nil || math.IsInf(opts.ZClampMin, 0) || math.IsInf(opts.ZClampMax, 0) || !(opts.ZClampMin < opts.ZClampMax) {
+				errs.add("zclamp", fmt.Errorf("cannot parse 'zclamp' %q to a range min,max of floats", zclampStr))
+			}
+		} else {
+			opts.ZClamp, err = strconv.ParseFloat(zclampStr, 64)
+			if err != nil || !(opts.ZClamp > 0) {
+				errs.add("zclamp", fmt.Errorf("cannot parse 'zclamp' %q to a positive float or a range min,max", zclampStr))
+			}
+		}
+	}
+	if zmapStr := q.Get("zmap"); zmapStr != "" {
+		switch zmapStr {
+		case "linear", "log", "sqrt":
+			opts.ZMap = zmapStr
+		default:
+			errs.add("zmap", fmt.Errorf("unknown value 'zmap'=%q, want linear, log or sqrt", zmapStr))
+		}
+	}
+	if contoursStr := q.Get("contours"); contoursStr != "" {
+		opts.Contours, err = strconv.ParseFloat(contoursStr, 64)
+		if err != nil || !(opts.Contours > 0) || math.IsInf(opts.Contours, 0) {
+			errs.add("contours", fmt.Errorf("cannot parse 'contours' %q to a positive interval", contoursStr))
+		}
+	}
+	if levelsStr := q.Get("contourlevels"); levelsStr != "" {
+		if q.Has("contours") {
+			errs.add("contourlevels", fmt.Errorf("set either 'contours' or 'contourlevels', not both"))
+		}
+		opts.ContourLevels, err = strconv.Atoi(levelsStr)
+		if err != nil || opts.ContourLevels < 1 || opts.ContourLevels > maxContourLevels {
+			errs.add("contourlevels", fmt.Errorf("cannot parse 'contourlevels' %q to a number of levels in 1..%d", levelsStr, maxContourLevels))
+		}
+	}
+	if colorStr := q.Get("contourcolor"); colorStr != "" {
+		opts.ContourColor, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("contourcolor", fmt.Errorf("cannot parse 'contourcolor': %v", err))
+		}
+	}
+	if widthStr := q.Get("contourwidth"); widthStr != "" {
+		opts.ContourWidth, err = strconv.ParseFloat(widthStr, 64)
+		if err != nil || !(opts.ContourWidth > 0) || math.IsInf(opts.ContourWidth, 0) {
+			errs.add("contourwidth", fmt.Errorf("cannot parse 'contourwidth' %q to a positive float", widthStr))
+		}
+	}
+	if scaleStr := q.Get("scale"); scaleStr != "" {
+		opts.Scale, err = strconv.ParseFloat(scaleStr, 64)
+		if err != nil || !(opts.Scale > 0 && opts.Scale <= maxScale) {
+			errs.add("scale", fmt.Errorf("cannot parse 'scale' %q to pixels per unit in (0, %d]", scaleStr, maxScale))
+		}
+	}
+	if zscaleStr := q.Get("zscale"); zscaleStr != "" {
+		opts.ZScale, err = strconv.ParseFloat(zscaleStr, 64)
+		if err != nil || !(opts.ZScale > 0 && opts.ZScale <= maxScale) {
+			errs.add("zscale", fmt.Errorf("cannot parse 'zscale' %q to pixels per unit in (0, %d]", zscaleStr, maxScale))
+		}
+	}
+	if fitStr := q.Get("fit"); fitStr != "" {
+		opts.Fit, err = strconv.ParseBool(fitStr)
+		if err != nil {
+			errs.add("fit", fmt.Errorf("cannot parse 'fit' %q to bool", fitStr))
+		}
+	}
+	if paddingStr := q.Get("padding"); paddingStr != "" {
+		opts.Padding, err = strconv.ParseFloat(paddingStr, 64)
+		if err != nil || !(opts.Padding >= 0) || 2*opts.Padding >= float64(min(opts.Width, opts.Height)) {
+			errs.add("padding", fmt.Errorf("cannot parse 'padding' %q to pixels less than half the canvas", paddingStr))
+		}
+	}
+	if wireframeStr := q.Get("wireframe"); wireframeStr != "" {
+		opts.Wireframe, err = strconv.ParseBool(wireframeStr)
+		if err != nil {
+			errs.add("wireframe", fmt.Errorf("cannot parse 'wireframe' %q to bool", wireframeStr))
+		}
+	}
+	switch styleStr := q.Get("style"); styleStr {
+	case "":
+	case "wireframe":
+		opts.Wireframe = true
+	case "solid":
+		opts.Wireframe = false
+	default:
+		errs.add("style", fmt.Errorf("unknown value 'style'=%q", styleStr))
+	}
+	if colorStr := q.Get("background"); colorStr != "" && colorStr != "transparent" {
+		opts.Background, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("background", fmt.Errorf("cannot parse 'background': %v", err))
+		}
+	}
+	if colorStr := q.Get("stroke"); colorStr != "" {
+		opts.Stroke, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("stroke", fmt.Errorf("cannot parse 'stroke': %v", err))
+		}
+		opts.Stroke.A = 255 // tell black from the zero value
+	}
+	if widthStr := q.Get("stroke-width"); widthStr != "" {
+		opts.StrokeWidth, err = strconv.ParseFloat(widthStr, 64)
+		if err != nil || !(opts.StrokeWidth > 0) || opts.StrokeWidth > 100 {
+			errs.add("stroke-width", fmt.Errorf("cannot parse 'stroke-width' %q to a width in (0, 100]", widthStr))
+		}
+	}
+	if opacityStr := q.Get("stroke-opacity"); opacityStr != "" {
+		opts.StrokeOpacity, err = strconv.ParseFloat(opacityStr, 64)
+		if err != nil || !(opts.StrokeOpacity > 0 && opts.StrokeOpacity <= 1) {
+			errs.add("stroke-opacity", fmt.Errorf("cannot parse 'stroke-opacity' %q to an opacity in (0, 1]", opacityStr))
+		}
+	}
+	if opacityStr := q.Get("fill-opacity"); opacityStr != "" {
+		opacityStr = listValues(q, "fill-opacity")[0]
+		opts.FillOpacity, err = strconv.ParseFloat(opacityStr, 64)
+		if err != nil || !(opts.FillOpacity > 0 && opts.FillOpacity <= 1) {
+			errs.add("fill-opacity", fmt.Errorf("cannot parse 'fill-opacity' %q to an opacity in (0, 1]", opacityStr))
+		}
+	}
+	if len(functions) > 1 {
+		layers, lerrs := parseLayers(q, functions[1:], lookup)
+		errs = append(errs, lerrs...)
+		if _, ok := opts.Projector.(ParametricProjector); ok {
+			errs.add("function", fmt.Errorf("functions drawn together must be height fields, not the parametric surface %s", function))
+		}
+		opts.Layers, function = layers, "overlay"
+	}
+	if tooltipsStr := q.Get("tooltips"); tooltipsStr != "" {
+		opts.Tooltips, err = strconv.ParseBool(tooltipsStr)
+		if err != nil {
+			errs.add("tooltips", fmt.Errorf("cannot parse 'tooltips' %q to bool", tooltipsStr))
+		}
+	}
+	// The title defaults to what is drawn, which the metadata names too.
+	subject := strings.Join(functions, ", ")
+	if exprStr := q.Get("expr"); exprStr != "" {
+		subject = exprStr
+	} else if subject == "" {
+		subject = "sin"
+	}
+	opts.Title, opts.Desc = q.Get("title"), q.Get("desc")
+	if opts.Title == "" {
+		opts.Title = subject
+	}
+	// The normalized query, as the cache keys renders on, so that equivalent
+	// queries draw the same SVG.
+	opts.Metadata = &Metadata{Function: subject, Parameters: normalizeQuery(q).Encode()}
+	if createdStr := q.Get("created"); createdStr != "" {
+		opts.Metadata.Created, err = time.Parse(time.RFC3339, createdStr)
+		if err != nil {
+			errs.add("created", fmt.Errorf("cannot parse 'created' %q to an RFC 3339 time", createdStr))
+		}
+	}
+	if mergeStr := q.Get("merge"); mergeStr != "" {
+		opts.Merge, err = strconv.ParseBool(mergeStr)
+		if err != nil {
+			errs.add("merge", fmt.Errorf("cannot parse 'merge' %q to bool", mergeStr))
+		}
+	}
+	if streamStr := q.Get("stream"); streamStr != "" {
+		opts.Stream, err = strconv.ParseBool(streamStr)
+		if err != nil {
+			errs.add("stream", fmt.Errorf("cannot parse 'stream' %q to bool", streamStr))
+		}
+	}
+	if legendStr := q.Get("legend"); legendStr != "" {
+		opts.Legend, err = strconv.ParseBool(legendStr)
+		if err != nil {
+			errs.add("legend", fmt.Errorf("cannot parse 'legend' %q to bool", legendStr))
+		}
+	}
+	if cullStr := q.Get("cull"); cullStr != "" {
+		opts.Cull, err = strconv.ParseBool(cullStr)
+		if err != nil {
+			errs.add("cull", fmt.Errorf("cannot parse 'cull' %q to bool", cullStr))
+		}
+	}
+	if smoothStr := q.Get("smooth"); smoothStr != "" {
+		opts.Smoothing, err = strconv.Atoi(smoothStr)
+		if err != nil || opts.Smoothing < 0 || opts.Smoothing > maxSmoothing {
+			errs.add("smooth", fmt.Errorf("cannot parse 'smooth' %q to a radius in cells in 0..%d", smoothStr, maxSmoothing))
+		}
+		if _, ok := opts.Projector.(ParametricProjector); ok && opts.Smoothing > 0 {
+			errs.add("smooth", fmt.Errorf("'smooth' applies to height fields, not the parametric surface %s", function))
+		}
+	}
+	switch opts.Holes = q.Get("holes"); opts.Holes {
+	case "", "skip":
+	case "interpolate", "fill":
+		if _, ok := opts.Projector.(ParametricProjector); ok {
+			errs.add("holes", fmt.Errorf("'holes'=%s applies to height fields, not the parametric surface %s", opts.Holes, function))
+		}
+	default:
+		errs.add("holes", fmt.Errorf("unknown value 'holes'=%q, want skip, interpolate or fill", opts.Holes))
+	}
+	if colorStr := q.Get("holecolor"); colorStr != "" {
+		opts.HoleColor, err = parseColor(colorStr)
+		if err != nil {
+			errs.add("holecolor", fmt.Errorf("cannot parse 'holecolor': %v", err))
+		}
+	}
+	switch opts.ColorBy = q.Get("colorby"); opts.ColorBy {
+	case "", "height":
+	case "slope":
+		if _, ok := opts.Projector.(ParametricProjector); ok {
+			errs.add("colorby", fmt.Errorf("'colorby'=slope applies to height fields, not the parametric surface %s", function))
+		}
+	default:
+		errs.add("colorby", fmt.Errorf("unknown value 'colorby'=%q, want height or slope", opts.ColorBy))
+	}
+	if q.Has("zmin") || q.Has("zmax") {
+		zminStr, zmaxStr := q.Get("zmin"), q.Get("zmax")
+		zmin, err1 := strconv.ParseFloat(zminStr, 64)
+		zmax, err2 := strconv.ParseFloat(zmaxStr, 64)
+		switch {
+		case err1 != nil || math.IsInf(zmin, 0):
+			errs.add("zmin", fmt.Errorf("cannot parse 'zmin' %q to a float; 'zmin' and 'zmax' go together", zminStr))
+		case err2 != nil || math.IsInf(zmax, 0):
+			errs.add("zmax", fmt.Errorf("cannot parse 'zmax' %q to a float; 'zmin' and 'zmax' go together", zmaxStr))
+		case !(zmin < zmax):
+			errs.add("zmin", fmt.Errorf("'zmin' %g is not less than 'zmax' %g", zmin, zmax))
+		default:
+			opts.ColorMin, opts.ColorMax = zmin, zmax
+		}
+	}
+	if colorrangeStr := q.Get("colorrange"); colorrangeStr != "" {
+		lo, hi, ok := strings.Cut(colorrangeStr, ",")
+		p0, err1 := strconv.ParseFloat(strings.TrimPrefix(lo, "p"), 64)
+		p1, err2 := strconv.ParseFloat(strings.TrimPrefix(hi, "p"), 64)
+		if !ok || !strings.HasPrefix(lo, "p") || !strings.HasPrefix(hi, "p") || err1 != nil || err2 != nil || !(p0 >= 0 && p0 < p1 && p1 <= 100) {
+			errs.add("colorrange", fmt.Errorf("cannot parse 'colorrange' %q to increasing percentiles such as p2,p98", colorrangeStr))
+		} else {
+			opts.Percentiles = [2]float64{p0, p1}
+			if opts.Stream {
+				errs.add("colorrange", fmt.Errorf("'colorrange' needs every cell first, which 'stream' does not keep"))
+			}
+		}
+	}
+	if gradientStr := q.Get("gradient"); gradientStr != "" {
+		opts.Gradient, err = strconv.ParseBool(gradientStr)
+		if err != nil {
+			errs.add("gradient", fmt.Errorf("cannot parse 'gradient' %q to bool", gradientStr))
+		}
+	}
+	if shadingStr := q.Get("shading"); shadingStr != "" {
+		switch shadingStr {
+		case "lambert":
+			opts.Shading = true
+		case "none":
+			opts.Shading = false
+		default:
+			opts.Shading, err = strconv.ParseBool(shadingStr)
+			if err != nil {
+				errs.add("shading", fmt.Errorf("cannot parse 'shading' %q to lambert, none or bool", shadingStr))
+			}
+		}
+	}
+	if lightStr := q.Get("light"); lightStr != "" {
+		azimuthStr, elevationStr, ok := strings.Cut(lightStr, ",")
+		azimuth, err1 := strconv.ParseFloat(strings.TrimSpace(azimuthStr), 64)
+		elevation, err2 := strconv.ParseFloat(strings.TrimSpace(elevationStr), 64)
+		if !ok || err1 != nil || err2 != nil || math.IsNaN(azimuth) || math.IsInf(azimuth, 0) || !(elevation >= -90 && elevation <= 90) {
+			errs.add("light", fmt.Errorf("cannot parse 'light' %q to an azimuth and an elevation in -90..90 degrees", lightStr))
+		}
+		opts.Light = [2]float64{math.Mod(azimuth, 360), elevation}
+	}
+
+	if zfactorStr := q.Get("zfactor"); zfactorStr != "" {
+		opts.ZFactor, err = strconv.ParseFloat(zfactorStr, 64)
+		if err != nil || !(opts.ZFactor > 0) || math.IsInf(opts.ZFactor, 0) {
+			errs.add("zfactor", fmt.Errorf("cannot parse 'zfactor' %q to a positive float", zfactorStr))
+		}
+	}
+	if pageStr := q.Get("page"); pageStr != "" {
+		opts.PageWidth, opts.PageHeight, err = parsePage(pageStr)
+		if err != nil {
+			errs.add("page", err)
+		}
+	}
+	if marginStr := q.Get("margin"); marginStr != "" {
+		opts.Margin, err = strconv.ParseFloat(marginStr, 64)
+		if err != nil || !(opts.Margin >= 0) || math.IsInf(opts.Margin, 0) {
+			errs.add("margin", fmt.Errorf("cannot parse 'margin' %q to a non-negative number of points", marginStr))
+		}
+	}
+
+	switch opts.Format = q.Get("format"); opts.Format {
+	case "", "svg", "json", "png", "gif", "pdf", "obj", "stl", "gltf":
+	default:
+		errs.add("format", fmt.Errorf("unknown value 'format'=%q", opts.Format))
+	}
+	if adaptiveStr := q.Get("adaptive"); adaptiveStr != "" {
+		opts.Adaptive, err = strconv.ParseFloat(adaptiveStr, 64)
+		if err != nil || !(opts.Adaptive > 0 && opts.Adaptive <= 1) {
+			errs.add("adaptive", fmt.Errorf("cannot parse 'adaptive' %q to a tolerance in (0, 1]", adaptiveStr))
+		}
+		_, timed := opts.Projector.(TimeProjector)
+		switch {
+		case opts.Format != "" && opts.Format != "svg" && opts.Format != "png" && opts.Format != "gif" && opts.Format != "pdf":
+			errs.add("adaptive", fmt.Errorf("'adaptive' applies to the svg, png, gif and pdf formats, not %s", opts.Format))
+		case opts.Stream:
+			errs.add("adaptive", fmt.Errorf("set either 'adaptive' or 'stream', not both"))
+		case opts.Animate && timed:
+			errs.add("adaptive", fmt.Errorf("'adaptive' cannot animate the changes of %s over time", function))
+		}
+	}
+	if refineStr := q.Get("refine"); refineStr != "" {
+		opts.Refine, err = strconv.Atoi(refineStr)
+		if err != nil || opts.Refine < 1 || opts.Refine > maxRefine {
+			errs.add("refine", fmt.Errorf("cannot parse 'refine' %q to a number of levels in 1..%d", refineStr, maxRefine))
+		}
+	}
+	switch opts.Mesh = q.Get("mesh"); opts.Mesh {
+	case "", "quad":
+	case "tri":
+		if opts.Format != "" && opts.Format != "svg" && opts.Format != "png" && opts.Format != "gif" && opts.Format != "pdf" {
+			errs.add("mesh", fmt.Errorf("'mesh'=tri applies to the svg, png, gif and pdf formats, not %s", opts.Format))
+		}
+	default:
+		errs.add("mesh", fmt.Errorf("unknown value 'mesh'=%q", opts.Mesh))
+	}
+
+	if len(errs) > 0 {
+		return opts, function, errs
+	}
+	return opts, function, nil
+}
+
+// parseStops parses the gradient in parameter name: at least two
+// comma-separated colors, evenly spaced, or each followed by "@" and its
+// position from 0 to 1, as in "0000ff@0,ffffff@0.5,ff0000@1".
+func parseStops(name, s string) ([]color.RGBA, []float64, error) {
+	var stops []color.RGBA
+	var offsets []float64
+	entries := strings.Split(s, ",")
+	for k, entry := range entries {
+		colorStr, offsetStr, positioned := strings.Cut(strings.TrimSpace(entry), "@")
+		c, err := parseColor(colorStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse '%s' entry: %v", name, err)
+		}
+		stops = append(stops, c)
+		if k > 0 && positioned != (offsets != nil) {
+			return nil, nil, fmt.Errorf("'%s' positions every color with @ or none, got %q", name, s)
+		}
+		if !positioned {
+			continue
+		}
+		x, err := strconv.ParseFloat(offsetStr, 64)
+		if err != nil || !(x >= 0 && x <= 1) || len(offsets) > 0 && x < offsets[len(offsets)-1] {
+			return nil, nil, fmt.Errorf("cannot parse '%s' position %q to an increasing number in [0, 1]", name, offsetStr)
+		}
+		offsets = append(offsets, x)
+	}
+	if len(stops) < 2 {
+		return nil, nil, fmt.Errorf("'%s' needs at least two colors, got %q", name, s)
+	}
+	return stops, offsets, nil
+}
+
+// parsePage parses a page size: a name in pageSizes, optionally followed by
+// "-landscape", or a width and height in points such as "600x400".
+func parsePage(s string) (width, height float64, err error) {
+	name, landscape := strings.CutSuffix(strings.ToLower(s), "-landscape")
+	if size, ok := pageSizes[name]; ok {
+		if landscape {
+			return size[1], size[0], nil
+		}
+		return size[0], size[1], nil
+	}
+	ws, hs, ok := strings.Cut(name, "x")
+	if ok && !landscape {
+		width, err1 := strconv.ParseFloat(ws, 64)
+		height, err2 := strconv.ParseFloat(hs, 64)
+		if err1 == nil && err2 == nil && width > 0 && height > 0 && width <= 14400 && height <= 14400 {
+			return width, height, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("cannot parse 'page' %q to a page size such as a4, letter-landscape or 600x400", s)
+}
+
+// listValues returns the values of the parameter name of q, whether
+// repeated or separated by commas, in order.
+func listValues(q url.Values, name string) []string {
+	var vs []string
+	for _, v := range q[name] {
+		for _, s := range strings.Split(v, ",") {
+			vs = append(vs, strings.TrimSpace(s))
+		}
+	}
+	return vs
+}
+
+// parseLayers parses the functions after the first of a render, drawn as
+// its Layers, with the colormap and fill-opacity values after the first as
+// theirs in turn.
+func parseLayers(q url.Values, functions []string, lookup func(string) (Projector, bool)) ([]Layer, ParamErrors) {
+	var errs ParamErrors
+	if len(functions) > maxLayers {
+		errs.add("function", fmt.Errorf("%d functions drawn together, more than %d", len(functions)+1, maxLayers+1))
+		return nil, errs
+	}
+	layers := make([]Layer, len(functions))
+	for k, name := range functions {
+		name = strings.ToLower(name)
+		p, ok := lookup(name)
+		if !ok && isComposite(name) {
+			var err error
+			if p, _, err = parseComposite(name, lookup); err != nil {
+				errs.add("function", err)
+				continue
+			}
+		} else if !ok {
+			errs.add("function", fmt.Errorf("unknown value 'function'=%q", name))
+			continue
+		}
+		if _, ok := p.(ParametricProjector); ok {
+			errs.add("function", fmt.Errorf("functions drawn together must be height fields, not the parametric surface %s", name))
+		}
+		layers[k].Projector = p
+	}
+	colormaps := listValues(q, "colormap")
+	for k := 1; k < len(colormaps) && k <= len(layers); k++ {
+		stops, ok := Colormap(strings.ToLower(colormaps[k]))
+		if !ok {
+			errs.add("colormap", fmt.Errorf("unknown value 'colormap'=%q, want one of %s", colormaps[k], strings.Join(Colormaps(), ", ")))
+		}
+		layers[k-1].Stops = stops
+	}
+	opacities := listValues(q, "fill-opacity")
+	for k := 1; k < len(opacities) && k <= len(layers); k++ {
+		v, err := strconv.ParseFloat(opacities[k], 64)
+		if err != nil || !(v > 0 && v <= 1) {
+			errs.add("fill-opacity", fmt.Errorf("cannot parse 'fill-opacity' %q to an opacity in (0, 1]", opacities[k]))
+		}
+		layers[k-1].Opacity = v
+	}
+	return layers, errs
+}
+
+// parseDomain parses the parameters axis+"min" and axis+"max", defaulting
+// either to the end of -span/2..span/2.
+func parseDomain(q url.Values, axis string, span float64) (lo, hi float64, err error) {
+	lo, hi = -span/2, span/2
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{{axis + "min", &lo}, {axis + "max", &hi}} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+		*p.v, err = strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(*p.v) || math.IsInf(*p.v, 0) {
+			return lo, hi, fmt.Errorf("cannot parse '%s' %q to a float", p.name, s)
+		}
+	}
+	if !(lo < hi) {
+		return lo, hi, fmt.Errorf("'%smin' %g is not less than '%smax' %g", axis, lo, axis, hi)
+	}
+	return lo, hi, nil
+}
+
+// parseTunings parses the parameters function.param of q, of Tunable
+// projectors, into the values of the parameters of each function.
+func parseTunings(q url.Values) (map[string]map[string]float64, ParamErrors) {
+	var errs ParamErrors
+	var keys []string
+	for key := range q {
+		if strings.Contains(key, ".") {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys) // for the errors to come in a stable order
+	tunings := make(map[string]map[string]float64)
+	for _, key := range keys {
+		name, param, _ := strings.Cut(key, ".")
+		p, _ := LookupProjector(name)
+		t, ok := p.(Tunable)
+		if !ok {
+			errs.add(key, fmt.Errorf("unknown parameter %q: %q is not a function with parameters", key, name))
+			continue
+		}
+		params := t.Params()
+		k := slices.IndexFunc(params, func(pp ProjectorParam) bool { return pp.Name == param })
+		if k < 0 {
+			names := make([]string, len(params))
+			for n, pp := range params {
+				names[n] = pp.Name
+			}
+			errs.add(key, fmt.Errorf("unknown parameter %q, want one of %s.%s", key, name, strings.Join(names, ", "+name+".")))
+			continue
+		}
+		s := q.Get(key)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(v > 0 && v <= params[k].Max) {
+			errs.add(key, fmt.Errorf("cannot parse '%s' %q to a float in (0, %g]", key, s, params[k].Max))
+			continue
+		}
+		if tunings[name] == nil {
+			tunings[name] = make(map[string]float64)
+		}
+		tunings[name][param] = v
+	}
+	return tunings, errs
+}
+
+// parseComplex parses a complex number written as its real and imaginary
+// parts, such as -0.8,0.156.
+func parseComplex(s string) (complex128, error) {
+	res, ims, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, fmt.Errorf("want re,im")
+	}
+	re, err1 := strconv.ParseFloat(strings.TrimSpace(res), 64)
+	im, err2 := strconv.ParseFloat(strings.TrimSpace(ims), 64)
+	if err1 != nil || err2 != nil || math.IsNaN(re+im) || math.IsInf(re+im, 0) {
+		return 0, fmt.Errorf("want re,im")
+	}
+	return complex(re, im), nil
+}
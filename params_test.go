@@ -0,0 +1,98 @@
+package surface
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// status returns the status of the response of h to a GET of target.
+func status(h http.Handler, target string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w.Code
+}
+
+func TestEqualPeakAndValley(t *testing.T) {
+	h := NewHandler(HandlerConfig{})
+	for target, want := range map[string]int{
+		"/?cells=10&peak=ff0000&valley=ff0000":            http.StatusBadRequest,
+		"/?cells=10&peak=ff0000&valley=red":               http.StatusBadRequest,
+		"/?cells=10&peak=ff0000&valley=ff0000&force=true": http.StatusOK,
+		"/?cells=10&peak=ff0000&valley=0000ff":            http.StatusOK,
+	} {
+		if got := status(h, target); got != want {
+			t.Errorf("%s: status %d, want %d", target, got, want)
+		}
+	}
+}
+
+func TestFunctionNameCase(t *testing.T) {
+	_, name, err := ParseQuery(mustQuery(t, "function=SaDdLe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "saddle" {
+		t.Errorf("name %q, want saddle", name)
+	}
+	h := NewHandler(HandlerConfig{})
+	for target, want := range map[string]int{
+		"/?cells=10&function=EggBox":  http.StatusOK,
+		"/?cells=10&function=nosuch":  http.StatusBadRequest,
+		"/?cells=10&function=sin,Foo": http.StatusBadRequest,
+	} {
+		if got := status(h, target); got != want {
+			t.Errorf("%s: status %d, want %d", target, got, want)
+		}
+	}
+}
+
+func TestCanvasSize(t *testing.T) {
+	h := NewHandler(HandlerConfig{})
+	for target, want := range map[string]int{
+		"/?cells=10&width=49":    http.StatusBadRequest,
+		"/?cells=10&width=4001":  http.StatusBadRequest,
+		"/?cells=10&height=49":   http.StatusBadRequest,
+		"/?cells=10&height=4001": http.StatusBadRequest,
+		"/?cells=10&width=wide":  http.StatusBadRequest,
+		"/?cells=10&width=50":    http.StatusOK,
+		"/?cells=10&height=4000": http.StatusOK,
+	} {
+		if got := status(h, target); got != want {
+			t.Errorf("%s: status %d, want %d", target, got, want)
+		}
+	}
+	// An odd width is not rounded down to a whole number of pixels per unit.
+	opts, _, err := ParseQuery(mustQuery(t, "width=601&height=301"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := opts.projection()
+	if want := 601.0 / 2 / 30; pr.xyscale != want {
+		t.Errorf("xyscale %g, want %g", pr.xyscale, want)
+	}
+	if want := 301 * 0.4; pr.zscale != want {
+		t.Errorf("zscale %g, want %g", pr.zscale, want)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	opts, _, err := ParseQuery(mustQuery(t, "smooth=2&gradient=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Smoothing != 2 || !opts.Gradient {
+		t.Errorf("smooth=2&gradient=1: Smoothing %d, Gradient %v; want 2 and true", opts.Smoothing, opts.Gradient)
+	}
+	h := NewHandler(HandlerConfig{})
+	for target, want := range map[string]int{
+		"/?cells=10&smooth=2":                http.StatusOK,
+		"/?cells=10&smooth=-1":               http.StatusBadRequest,
+		"/?cells=10&smooth=51":               http.StatusBadRequest,
+		"/?cells=10&smooth=2&function=torus": http.StatusBadRequest,
+	} {
+		if got := status(h, target); got != want {
+			t.Errorf("%s: status %d, want %d", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package surface
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// pdfDocument writes the surface for opts as a single-page PDF. The canvas,
+// or with opts.Fit the viewBox, is scaled to fit the page inside its
+// margins and centered, keeping the cells vector paths.
+func pdfDocument(ctx context.Context, w io.Writer, opts Options) error {
+	m, err := sample(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	levels, err := contourLevels(m.bounds, opts)
+	if err != nil {
+		return err
+	}
+	pw, ph := opts.PageWidth, opts.PageHeight
+	if pw == 0 || ph == 0 {
+		pw, ph = float64(opts.Width)+2*opts.Margin, float64(opts.Height)+2*opts.Margin
+	}
+	aw, ah := pw-2*opts.Margin, ph-2*opts.Margin
+	if !(aw > 0 && ah > 0) {
+		return fmt.Errorf("surface: Margin %g leaves no room on a %g×%g page", opts.Margin, pw, ph)
+	}
+
+	vx, vy, vw, vh := 0.0, 0.0, float64(opts.Width), float64(opts.Height)
+	if opts.Fit {
+		vx, vy, vw, vh = m.viewRect(opts.Width, opts.Height, opts.Padding)
+	}
+	s := min(aw/vw, ah/vh)
+	tx := opts.Margin + (aw-vw*s)/2 - vx*s
+	ty := ph - opts.Margin - (ah-vh*s)/2 + vy*s
+
+	var c []byte
+	num := func(f float64) {
+		c = appendCoord(c, f, opts.Precision)
+		c = append(c, ' ')
+	}
+	rgb := func(col color.RGBA, op string) {
+		c = fmt.Appendf(c, "%.3f %.3f %.3f %s\n", float64(col.R)/255, float64(col.G)/255, float64(col.B)/255, op)
+	}
+	// Flip the y axis and scale the canvas onto the page, then clip to the
+	// visible part of the canvas as an SVG viewer does.
+	c = fmt.Appendf(c, "%.6g 0 0 %.6g %.6g %.6g cm\n", s, -s, tx, ty)
+	c = fmt.Appendf(c, "%g %g %g %g re W n\n", vx, vy, vw, vh)
+	if bg := opts.Background; bg.A > 0 {
+		rgb(bg, "rg")
+		c = fmt.Appendf(c, "%g %g %g %g re f\n", vx, vy, vw, vh)
+	}
+	// The opacity of the outlines is a graphics state parameter, which the
+	// contours must not inherit.
+	c = append(c, "q /Outline gs\n"...)
+	rgb(opts.Stroke, "RG")
+	c = fmt.Appendf(c, "%g w 1 j\n", opts.StrokeWidth)
+	for n, ij := range m.order {
+		if err := m.checkpoint(ctx, n); err != nil {
+			return err
+		}
+		p := m.polygons[ij[0]][ij[1]]
+		if !opts.Wireframe {
+			rgb(m.color(p, opts), "rg")
+		}
+		for k := 0; k < 2*p.sides(); k += 2 {
+			num(p.points[k])
+			num(p.points[k+1])
+			if k == 0 {
+				c = append(c, "m "...)
+			} else {
+				c = append(c, "l "...)
+			}
+		}
+		switch {
+		case opts.Wireframe:
+			c = append(c, "s\n"...)
+		case !opts.outlined():
+			c = append(c, "f\n"...)
+		default:
+			c = append(c, "b\n"...)
+		}
+	}
+	c = append(c, "Q\n"...)
+	if len(levels) > 0 {
+		rgb(opts.ContourColor, "RG")
+		c = fmt.Appendf(c, "%g w\n", opts.ContourWidth)
+	}
+	for _, level := range levels {
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				if !p.valid {
+					continue
+				}
+				for _, seg := range p.contourSegments(level) {
+					num(seg[0])
+					num(seg[1])
+					c = append(c, "m "...)
+					num(seg[2])
+					num(seg[3])
+					c = append(c, "l S\n"...)
+				}
+			}
+		}
+	}
+
+	var content bytes.Buffer
+	zw := zlib.NewWriter(&content)
+	zw.Write(c)
+	zw.Close()
+	resources := fmt.Sprintf("<< /ExtGState << /Outline << /CA %g >> >> >>", opts.StrokeOpacity)
+	return writePDF(w, pw, ph, resources, content.Bytes())
+}
+
+// writePDF writes a PDF file of one pw×ph page drawn by the Flate-compressed
+// content stream with the resources dictionary.
+func writePDF(w io.Writer, pw, ph float64, resources string, content []byte) error {
+	var b bytes.Buffer
+	var offsets []int
+	obj := func(format string, a ...any) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&b, format, a...)
+		b.WriteString("\nendobj\n")
+	}
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	obj("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Contents 4 0 R /Resources %s >>", pw, ph, resources)
+	obj("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(content), content)
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,192 @@
+package surface
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// pngImage rasterizes the surface for opts into a Width×Height PNG with a
+// transparent background. Cells are filled and outlined in the same order
+// and colors as the SVG; animation is not supported and renders one view.
+func pngImage(ctx context.Context, w io.Writer, opts Options) error {
+	m, err := sample(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	levels, err := contourLevels(m.bounds, opts)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	fillBackground(img, opts)
+	if err := rasterize(ctx, img, m, levels, newTransform(m.bounds, opts), opts); err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// rasterize draws the cells of m and the contours at levels onto img, with
+// coordinates mapped by tr.
+func rasterize(ctx context.Context, img *image.RGBA, m *mesh, levels []float64, tr transform, opts Options) error {
+	stroke := opaque(opts.Stroke)
+	for n, ij := range m.order {
+		if err := m.checkpoint(ctx, n); err != nil {
+			return err
+		}
+		p := m.polygons[ij[0]][ij[1]]
+		pts := tr.apply(p.points)
+		n := p.sides()
+		if !opts.Wireframe {
+			c := m.color(p, opts)
+			c.A = uint8(255*opts.fillOpacity(c) + 0.5)
+			fillPolygon(img, pts[:2*n], c)
+		}
+		for k := 0; k < n && opts.outlined(); k++ {
+			l := (k + 1) % n
+			drawLine(img, pts[2*k], pts[2*k+1], pts[2*l], pts[2*l+1], stroke, opts.StrokeWidth*opts.StrokeOpacity)
+		}
+	}
+
+	c := opaque(opts.ContourColor)
+	for _, level := range levels {
+		for i := range m.polygons {
+			for _, p := range m.polygons[i] {
+				if !p.valid {
+					continue
+				}
+				p.points = tr.apply(p.points)
+				for _, s := range p.contourSegments(level) {
+					drawLine(img, s[0], s[1], s[2], s[3], c, opts.ContourWidth)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// transform maps projected coordinates onto the pixels of the image: the
+// identity, or with opts.Fit the viewBox scaled to fit and centered, as an
+// SVG viewer does.
+type transform struct {
+	scale, dx, dy float64
+}
+
+func newTransform(b bounds, opts Options) transform {
+	if !opts.Fit {
+		return transform{scale: 1}
+	}
+	x, y, w, h := b.viewRect(opts.Width, opts.Height, opts.Padding)
+	s := min(float64(opts.Width)/w, float64(opts.Height)/h)
+	return transform{
+		scale: s,
+		dx:    (float64(opts.Width)-w*s)/2 - x*s,
+		dy:    (float64(opts.Height)-h*s)/2 - y*s,
+	}
+}
+
+func (t transform) apply(pts [8]float64) [8]float64 {
+	for k := 0; k < len(pts); k += 2 {
+		pts[k] = pts[k]*t.scale + t.dx
+		pts[k+1] = pts[k+1]*t.scale + t.dy
+	}
+	return pts
+}
+
+// fillBackground fills img with opts.Background.
+func fillBackground(img *image.RGBA, opts Options) {
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+}
+
+func opaque(c color.RGBA) color.RGBA {
+	c.A = 255
+	return c
+}
+
+// fillPolygon fills the polygon with vertices pts (x, y pairs) with c,
+// coloring each pixel whose center lies inside it by the even-odd rule. A
+// translucent c is blended over the pixels.
+func fillPolygon(img *image.RGBA, pts []float64, c color.RGBA) {
+	scanPolygon(pts, img.Bounds(), func(x0, x1, y int) {
+		for x := x0; x < x1; x++ {
+			if c.A == 255 {
+				img.SetRGBA(x, y, c)
+			} else {
+				img.SetRGBA(x, y, blend(img.RGBAAt(x, y), opaque(c), float64(c.A)/255))
+			}
+		}
+	})
+}
+
+// scanPolygon calls span for each run of pixels x0 ≤ x < x1 in row y of r
+// whose centers lie inside the polygon with vertices pts, by the even-odd
+// rule.
+func scanPolygon(pts []float64, r image.Rectangle, span func(x0, x1, y int)) {
+	ymin, ymax := math.Inf(1), math.Inf(-1)
+	for k := 1; k < len(pts); k += 2 {
+		ymin, ymax = min(ymin, pts[k]), max(ymax, pts[k])
+	}
+	y0 := max(int(math.Floor(ymin)), r.Min.Y)
+	y1 := min(int(math.Ceil(ymax)), r.Max.Y-1)
+	n := len(pts) / 2
+	xs := make([]float64, 0, n)
+	for y := y0; y <= y1; y++ {
+		yc := float64(y) + 0.5
+		xs = xs[:0]
+		for k := 0; k < n; k++ {
+			ax, ay := pts[2*k], pts[2*k+1]
+			bx, by := pts[2*((k+1)%n)], pts[2*((k+1)%n)+1]
+			if (ay <= yc) == (by <= yc) {
+				continue
+			}
+			xs = append(xs, ax+(yc-ay)/(by-ay)*(bx-ax))
+		}
+		sort.Float64s(xs)
+		for k := 0; k+1 < len(xs); k += 2 {
+			x0 := max(int(math.Ceil(xs[k]-0.5)), r.Min.X)
+			x1 := min(int(math.Ceil(xs[k+1]-0.5)), r.Max.X)
+			if x0 < x1 {
+				span(x0, x1, y)
+			}
+		}
+	}
+}
+
+// drawLine draws a line from (x0,y0) to (x1,y1) in c, blending each pixel
+// it passes through by width, which approximates the coverage of lines
+// thinner than a pixel. Thicker lines are drawn one pixel wide.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA, width float64) {
+	alpha := min(width, 1)
+	steps := int(math.Ceil(max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	r := img.Bounds()
+	for s := 0; s <= steps; s++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(s) / float64(steps)
+		}
+		p := image.Pt(int(math.Floor(x0+t*(x1-x0))), int(math.Floor(y0+t*(y1-y0))))
+		if !p.In(r) {
+			continue
+		}
+		img.SetRGBA(p.X, p.Y, blend(img.RGBAAt(p.X, p.Y), c, alpha))
+	}
+}
+
+// blend composites c with opacity alpha over dst.
+func blend(dst, c color.RGBA, alpha float64) color.RGBA {
+	mix := func(d, s uint8) uint8 {
+		return uint8(float64(d)*(1-alpha) + float64(s)*alpha + 0.5)
+	}
+	return color.RGBA{
+		R: mix(dst.R, c.R),
+		G: mix(dst.G, c.G),
+		B: mix(dst.B, c.B),
+		A: mix(dst.A, c.A),
+	}
+}
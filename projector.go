@@ -1,61 +1,349 @@
-package main
+package surface
 
 import "math"
 
+// Projector is a surface function. Corner returns the point (x,y,z) of the
+// surface at corner (i,j) of grid g.
 type Projector interface {
-	corner(i, j int) (float64, float64, float64)
+	Corner(g Grid, i, j int) (float64, float64, float64)
 }
 
+// TimeProjector is a surface function that changes over time. CornerAt
+// returns the point of the surface at time t, which runs from 0 to 1 over
+// one loop of an animation.
+type TimeProjector interface {
+	Projector
+	CornerAt(g Grid, i, j int, t float64) (float64, float64, float64)
+}
+
+// atTime is a TimeProjector frozen at time t.
+type atTime struct {
+	p TimeProjector
+	t float64
+}
+
+func (a atTime) Corner(g Grid, i, j int) (float64, float64, float64) {
+	return a.p.CornerAt(g, i, j, a.t)
+}
+
+// Describer is implemented by projectors that can describe their surface in
+// a short phrase, for listings of the available functions.
+type Describer interface {
+	Description() string
+}
+
+// Tunable is implemented by projectors with parameters of their own, which
+// a query sets as function.param, such as eggbox.amp. Params declares them,
+// and Tune returns the projector with some of them set to values, each
+// checked against its declaration.
+type Tunable interface {
+	Projector
+	Params() []ProjectorParam
+	Tune(values map[string]float64) Projector
+}
+
+// ProjectorParam declares a parameter of a Tunable projector, whose values
+// are in (0, Max].
+type ProjectorParam struct {
+	Name        string  `json:"name"`
+	Default     float64 `json:"default"`
+	Max         float64 `json:"max"`
+	Description string  `json:"description"`
+}
+
+// or returns v, or def if v is 0.
+func or(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// SinProjector is sin(r)/r of the distance r from the origin: a ripple.
 type SinProjector struct{}
 
-func (SinProjector) corner(i, j int) (float64, float64, float64) {
-	x, y := corner(i, j)
+func (SinProjector) Description() string {
+	return "sin(r)/r of the distance r from the origin: a ripple"
+}
+
+func (SinProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
 	r := math.Hypot(x, y) // distance from (0,0)
 	z := math.Sin(r) / r
 	return x, y, z
 }
 
-type EggboxProjector struct{}
+// WaveProjector is the ripple of SinProjector travelling outwards from the
+// origin, one wavelength per loop.
+type WaveProjector struct{}
 
-func (EggboxProjector) corner(i, j int) (float64, float64, float64) {
-	x, y := corner(i, j)
-	r := 10.0
-	z := (math.Sin(x) + math.Sin(y)) / r
+func (WaveProjector) Description() string {
+	return "a ripple travelling outwards from the origin"
+}
+
+func (p WaveProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	return p.CornerAt(g, i, j, 0)
+}
+
+func (WaveProjector) CornerAt(g Grid, i, j int, t float64) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	r := math.Hypot(x, y)
+	z := math.Sin(r-2*math.Pi*t) / r
 	return x, y, z
 }
 
-type MogulsProjector struct{}
+// EggboxProjector is a grid of alternating bumps and dips. Zero fields take
+// their defaults.
+type EggboxProjector struct {
+	Amp  float64 // height of the bumps, 0.1
+	Freq float64 // radians per unit of x and y, 1
+}
+
+func (EggboxProjector) Description() string { return "a grid of alternating bumps and dips" }
+
+func (EggboxProjector) Params() []ProjectorParam {
+	return []ProjectorParam{
+		{"amp", 0.1, 10, "height of the bumps"},
+		{"freq", 1, 100, "radians per unit of x and y"},
+	}
+}
+
+func (p EggboxProjector) Tune(values map[string]float64) Projector {
+	p.Amp, p.Freq = or(values["amp"], p.Amp), or(values["freq"], p.Freq)
+	return p
+}
 
-func (MogulsProjector) corner(i, j int) (float64, float64, float64) {
-	x, y := corner(i, j)
-	a := 0.01
-	b := 0.01
-	q := (2 * math.Pi) / 4.0
-	p := (2 * math.Pi) / 10.0
-	z := -a*x - b*math.Cos(p*x)*math.Cos(q*y)
+func (p EggboxProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	r, freq := 10.0, or(p.Freq, 1)
+	if p.Amp != 0 {
+		r = 1 / p.Amp
+	}
+	z := (math.Sin(freq*x) + math.Sin(freq*y)) / r
 	return x, y, z
 }
 
-type SaddleProjector struct{}
+// MogulsProjector is a slope covered in moguls. Zero fields take their
+// defaults.
+type MogulsProjector struct {
+	Slope   float64 // fall in z per unit of x, 0.01
+	Amp     float64 // height of the moguls, 0.01
+	Period  float64 // units of x between moguls, 10
+	Spacing float64 // units of y between moguls, 4
+}
+
+func (MogulsProjector) Description() string { return "a slope covered in moguls" }
+
+func (MogulsProjector) Params() []ProjectorParam {
+	return []ProjectorParam{
+		{"slope", 0.01, 1, "fall in z per unit of x"},
+		{"amp", 0.01, 10, "height of the moguls"},
+		{"period", 10, 1000, "units of x between moguls"},
+		{"spacing", 4, 1000, "units of y between moguls"},
+	}
+}
+
+func (p MogulsProjector) Tune(values map[string]float64) Projector {
+	p.Slope, p.Amp = or(values["slope"], p.Slope), or(values["amp"], p.Amp)
+	p.Period, p.Spacing = or(values["period"], p.Period), or(values["spacing"], p.Spacing)
+	return p
+}
 
-func (SaddleProjector) corner(i, j int) (float64, float64, float64) {
-	x, y := corner(i, j)
-	a := 0.1
-	b := 0.05
+func (p MogulsProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	a := or(p.Slope, 0.01)
+	b := or(p.Amp, 0.01)
+	q := (2 * math.Pi) / or(p.Spacing, 4)
+	r := (2 * math.Pi) / or(p.Period, 10)
+	z := -a*x - b*math.Cos(r*x)*math.Cos(q*y)
+	return x, y, z
+}
+
+// SaddleProjector is a hyperbolic paraboloid. Zero fields take their
+// defaults.
+type SaddleProjector struct {
+	A float64 // curvature upwards along x, 0.1
+	B float64 // curvature downwards along y, 0.05
+}
+
+func (SaddleProjector) Description() string { return "a hyperbolic paraboloid" }
+
+func (SaddleProjector) Params() []ProjectorParam {
+	return []ProjectorParam{
+		{"a", 0.1, 10, "curvature upwards along x"},
+		{"b", 0.05, 10, "curvature downwards along y"},
+	}
+}
+
+func (p SaddleProjector) Tune(values map[string]float64) Projector {
+	p.A, p.B = or(values["a"], p.A), or(values["b"], p.B)
+	return p
+}
+
+func (p SaddleProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	a := or(p.A, 0.1)
+	b := or(p.B, 0.05)
 	z := math.Pow(a*x, 2) - math.Pow(b*y, 2)
 	return x, y, z
 }
 
-// Find point (x,y) at corner of cell (i,j).
-func corner(i, j int) (float64, float64) {
-	x := xyrange * (float64(i)/cells - 0.5)
-	y := xyrange * (float64(j)/cells - 0.5)
+// Grid maps cell indices onto the function domain.
+type Grid struct {
+	xc, yc       float64 // center of the domain
+	xspan, yspan float64 // extent of the domain along each axis
+	nx, ny       int     // cells along each axis
+}
+
+// Corner finds point (x,y) at corner of cell (i,j).
+func (g Grid) Corner(i, j int) (float64, float64) {
+	x := g.xc + g.xspan*(float64(i)/float64(g.nx)-0.5)
+	y := g.yc + g.yspan*(float64(j)/float64(g.ny)-0.5)
 	return x, y
 }
 
-// Project (x,y,z) isometrically onto 2-D SVG canvas (sx,sy).
-func project(x, y, z float64) (float64, float64) {
-	sx := width/2 + (x-y)*cos30*xyscale
-	sy := height/2 + (x+y)*sin30*xyscale - z*zscale
-	return sx, sy
+// Cells returns the number of cells along x and y, so corners run from
+// (0,0) to (nx,ny).
+func (g Grid) Cells() (nx, ny int) {
+	return g.nx, g.ny
+}
+
+// Rotate (x,y) about the origin by the angle with the given sine and cosine.
+func rotate(x, y, sin, cos float64) (float64, float64) {
+	return x*cos - y*sin, x*sin + y*cos
+}
+
+// projection maps the surface onto the canvas.
+type projection struct {
+	cx, cy  float64 // center of the canvas
+	xyscale float64 // pixels per x or y unit
+	zscale  float64 // pixels per z unit
+	// The canvas offset of (x,y,z) from the center is
+	// ((ux*x + uy*y)*xyscale, (vx*x + vy*y)*xyscale - vz*z*zscale).
+	ux, uy, vx, vy, vz float64
+	persp              *perspective // if not nil, replaces the above
+	light              [3]float64   // unit direction towards the light
+	// back is the unit direction towards the camera in world coordinates,
+	// in which heights are multiplied by zworld to keep the exaggeration
+	// of the canvas.
+	back   [3]float64
+	zworld float64
+	// flipy mirrors the canvas vertically so that its y axis grows upwards,
+	// as in mathematical convention, instead of downwards as in SVG.
+	flipy bool
+	// twoSided lights each cell on the side facing the viewer, for
+	// surfaces such as a sphere whose cells may face away from it.
+	twoSided bool
+}
+
+// newProjection returns the projection that fits a domain of xyrange units
+// across a width×height canvas.
+func newProjection(width, height int, xyrange float64) projection {
+	pr := projection{
+		cx: float64(width) / 2,
+		cy: float64(height) / 2,
+		ux: cos30, uy: -cos30,
+		vx: sin30, vy: sin30, vz: 1,
+	}
+	sinEl, cosEl := math.Sincos(isoElevation * math.Pi / 180)
+	pr.back = [3]float64{cosEl / math.Sqrt2, cosEl / math.Sqrt2, sinEl}
+	pr.rescale(float64(width)/2/xyrange, float64(height)*0.4)
+	return pr
+}
+
+// rescale sets the pixels per x or y unit and per z unit of pr.
+func (pr *projection) rescale(xyscale, zscale float64) {
+	pr.xyscale, pr.zscale = xyscale, zscale
+	pr.zworld = zscale / (xyscale * math.Sqrt2 * cos30 * math.Cos(isoElevation*math.Pi/180))
+}
+
+// flatten replaces the view of pr by one from straight above that fits
+// the xspan×yspan domain to the width×height canvas, with y upwards.
+func (pr *projection) flatten(width, height int, xspan, yspan float64) {
+	pr.ux, pr.uy = float64(width)/xspan/pr.xyscale, 0
+	pr.vx, pr.vy, pr.vz = 0, -float64(height)/yspan/pr.xyscale, 0
+	pr.back = [3]float64{} // no cell hides another
+}
+
+// isoElevation is the elevation in degrees of the default view, at which
+// the x and y axes appear 30° from the horizontal.
+var isoElevation = math.Asin(math.Tan(angle)) * 180 / math.Pi
+
+// orbit moves the camera of pr azimuth degrees counter-clockwise about the z
+// axis from the default view, to elevation degrees above the xy plane, and
+// magnifies the canvas by zoom. orbit(0, isoElevation, 1) leaves pr as it
+// is. The scales keep the proportions of the default view: horizontal
+// lengths do not shrink as the camera rises, and heights shrink with the
+// cosine of the elevation.
+func (pr *projection) orbit(azimuth, elevation, zoom float64) {
+	// The default view looks along the diagonal x = y, so the screen axes
+	// are x and y rotated by 45°.
+	sin, cos := math.Sincos((45 - azimuth) * math.Pi / 180)
+	h := math.Sqrt2 * cos30 * zoom // horizontal scale of the default view
+	sinEl, cosEl := math.Sincos(elevation * math.Pi / 180)
+	pr.ux, pr.uy = cos*h, -sin*h
+	pr.vx, pr.vy = sin*h*sinEl, cos*h*sinEl
+	pr.vz = zoom * cosEl / math.Cos(isoElevation*math.Pi/180)
+	pr.back = [3]float64{sin * cosEl, cos * cosEl, sinEl}
+}
+
+// perspective is a pinhole camera looking at the center of the domain.
+type perspective struct {
+	right, down [3]float64 // camera axes in world coordinates
+	distance    float64    // from the camera to the center
+	focal       float64    // pixels per unit of x/depth
+}
+
+// perspective replaces the orthographic view of pr by a camera distance
+// units from the center of the domain, at azimuth and elevation as in
+// orbit, whose field of view of fov degrees spans the width of the canvas
+// before magnification by zoom. Heights keep the exaggeration of the
+// orthographic view.
+func (pr *projection) perspective(azimuth, elevation, zoom, fov, distance float64) {
+	sin, cos := math.Sincos((45 - azimuth) * math.Pi / 180)
+	sinEl, cosEl := math.Sincos(elevation * math.Pi / 180)
+	pr.back = [3]float64{sin * cosEl, cos * cosEl, sinEl}
+	pr.persp = &perspective{
+		right:    [3]float64{cos, -sin, 0},
+		down:     [3]float64{sin * sinEl, cos * sinEl, -cosEl},
+		distance: distance,
+		focal:    zoom * pr.cx / math.Tan(fov*math.Pi/360),
+	}
+}
+
+// depth returns how far (x,y,z) is from the viewer, in world units along
+// the line of sight or, through a perspective camera, from the camera: the
+// larger, the further back.
+func (pr projection) depth(x, y, z float64) float64 {
+	p := [3]float64{x, y, z * pr.zworld}
+	if c := pr.persp; c != nil {
+		d := [3]float64{c.distance*pr.back[0] - p[0], c.distance*pr.back[1] - p[1], c.distance*pr.back[2] - p[2]}
+		return math.Sqrt(dot(d, d))
+	}
+	return -dot(p, pr.back)
+}
+
+// Project (x,y,z) orthographically, or through the perspective camera if
+// there is one, onto 2-D SVG canvas (sx,sy). Points behind the camera
+// project to NaN.
+func (pr projection) project(x, y, z float64) (float64, float64) {
+	if c := pr.persp; c != nil {
+		p := [3]float64{x, y, z * pr.zworld}
+		depth := c.distance - dot(p, pr.back)
+		if depth <= 0 {
+			return math.NaN(), math.NaN()
+		}
+		sx := pr.cx + c.focal*dot(p, c.right)/depth
+		dy := c.focal * dot(p, c.down) / depth
+		if pr.flipy {
+			dy = -dy
+		}
+		return sx, pr.cy + dy
+	}
+	sx := pr.cx + (x*pr.ux+y*pr.uy)*pr.xyscale
+	dy := (x*pr.vx+y*pr.vy)*pr.xyscale - z*pr.vz*pr.zscale
+	if pr.flipy {
+		dy = -dy
+	}
+	return sx, pr.cy + dy
 }
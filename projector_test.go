@@ -0,0 +1,19 @@
+package surface
+
+import "testing"
+
+func TestFlipY(t *testing.T) {
+	opts := DefaultOptions()
+	x, y := opts.grid().Corner(0, 0)
+	pr := opts.projection()
+	_, down := pr.project(x, y, 1)
+	opts.FlipY = true
+	pr = opts.projection()
+	_, up := pr.project(x, y, 1)
+	if down-pr.cy == 0 {
+		t.Fatalf("corner (%g,%g) projects onto the middle of the canvas", x, y)
+	}
+	if up-pr.cy != -(down - pr.cy) {
+		t.Errorf("flipy moves the corner from %g to %g about the middle %g", down, up, pr.cy)
+	}
+}
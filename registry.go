@@ -0,0 +1,62 @@
+package surface
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	projectorsMu sync.RWMutex
+	projectors   = make(map[string]Projector)
+)
+
+func init() {
+	RegisterProjector("sin", SinProjector{})
+	RegisterProjector("eggbox", EggboxProjector{})
+	RegisterProjector("moguls", MogulsProjector{})
+	RegisterProjector("saddle", SaddleProjector{})
+	RegisterProjector("wave", WaveProjector{})
+	RegisterProjector("torus", TorusProjector{})
+	RegisterProjector("sphere", SphereProjector{})
+	RegisterProjector("mobius", MobiusProjector{})
+	RegisterProjector("terrain", NewTerrainProjector(0, defaultOctaves, defaultRoughness))
+	RegisterProjector("mandelbrot", NewMandelbrotProjector(defaultIterations, 1))
+	RegisterProjector("julia", NewJuliaProjector(defaultJulia, defaultIterations, 1))
+	RegisterProjector("harmonic", NewHarmonicProjector(defaultDegree, defaultOrder))
+}
+
+// RegisterProjector makes p available under name, which is matched
+// case-insensitively by callers such as the HTTP server. It panics if name
+// is already registered or p is nil.
+func RegisterProjector(name string, p Projector) {
+	projectorsMu.Lock()
+	defer projectorsMu.Unlock()
+	if p == nil {
+		panic("surface: RegisterProjector of nil projector " + name)
+	}
+	if _, dup := projectors[name]; dup {
+		panic(fmt.Sprintf("surface: RegisterProjector called twice for %q", name))
+	}
+	projectors[name] = p
+}
+
+// LookupProjector returns the projector registered under name.
+func LookupProjector(name string) (Projector, bool) {
+	projectorsMu.RLock()
+	defer projectorsMu.RUnlock()
+	p, ok := projectors[name]
+	return p, ok
+}
+
+// Projectors returns the sorted names of the registered projectors.
+func Projectors() []string {
+	projectorsMu.RLock()
+	defer projectorsMu.RUnlock()
+	names := make([]string, 0, len(projectors))
+	for name := range projectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
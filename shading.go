@@ -0,0 +1,69 @@
+package surface
+
+import (
+	"image/color"
+	"math"
+)
+
+const ambient = 0.3 // brightness of cells facing away from the light
+
+// defaultLight is the azimuth and elevation in degrees of the light source:
+// 45° above the horizon on the -x side, which is the upper left of the
+// rendered image.
+var defaultLight = [2]float64{180, 45}
+
+// lightVector returns the unit direction towards a light at azimuth degrees
+// counter-clockwise from the +x axis and elevation degrees above the
+// horizon.
+func lightVector(azimuth, elevation float64) [3]float64 {
+	sinAz, cosAz := math.Sincos(azimuth * math.Pi / 180)
+	sinEl, cosEl := math.Sincos(elevation * math.Pi / 180)
+	return [3]float64{cosAz * cosEl, sinAz * cosEl, sinEl}
+}
+
+// lambert returns the brightness of the quad with 3-D corners a, b, c, d
+// (in grid order) under diffuse lighting from pr.light. Cells facing away
+// from the light are not culled but darkened to ambient.
+func lambert(pr projection, a, b, c, d [3]float64) float64 {
+	// Heights are exaggerated on the canvas by zscale/xyscale relative to
+	// x and y; compute the normal in the same proportions the viewer sees.
+	zfactor := pr.zscale / pr.xyscale
+	u := [3]float64{d[0] - b[0], d[1] - b[1], (d[2] - b[2]) * zfactor}
+	v := [3]float64{c[0] - a[0], c[1] - a[1], (c[2] - a[2]) * zfactor}
+	n := normalize(cross(u, v))
+	if pr.twoSided && dot(n, pr.back) < 0 {
+		n = [3]float64{-n[0], -n[1], -n[2]}
+	}
+	diffuse := max(0, dot(n, pr.light))
+	if math.IsNaN(diffuse) {
+		return 1
+	}
+	return ambient + (1-ambient)*diffuse
+}
+
+// shade scales the brightness of c by f.
+func shade(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) * f),
+		G: uint8(float64(c.G) * f),
+		B: uint8(float64(c.B) * f),
+		A: c.A,
+	}
+}
+
+func cross(u, v [3]float64) [3]float64 {
+	return [3]float64{
+		u[1]*v[2] - u[2]*v[1],
+		u[2]*v[0] - u[0]*v[2],
+		u[0]*v[1] - u[1]*v[0],
+	}
+}
+
+func dot(u, v [3]float64) float64 {
+	return u[0]*v[0] + u[1]*v[1] + u[2]*v[2]
+}
+
+func normalize(v [3]float64) [3]float64 {
+	l := math.Sqrt(dot(v, v))
+	return [3]float64{v[0] / l, v[1] / l, v[2] / l}
+}
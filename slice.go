@@ -0,0 +1,217 @@
+package surface
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Margins of the plot area of a slice, in pixels, leaving room for the
+// tick labels.
+const sliceLeft, sliceRight, sliceTop, sliceBottom = 56.0, 16.0, 24.0, 40.0
+
+// sliceSVG writes for opts a line chart of the heights along the cut where
+// SliceAxis is SliceAt, with the other axis across, instead of a view of
+// the whole surface. Undefined heights break the line.
+func sliceSVG(ctx context.Context, w io.Writer, opts Options) error {
+	end := opts.trace("sample")
+	ts, zs := sliceHeights(opts)
+	end()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+
+	width, height := float64(opts.Width), float64(opts.Height)
+	pw, ph := width-sliceLeft-sliceRight, height-sliceTop-sliceBottom
+	if pw <= 0 || ph <= 0 {
+		return fmt.Errorf("surface: canvas %d×%d is too small for a slice", opts.Width, opts.Height)
+	}
+	tmin, tmax := ts[0], ts[len(ts)-1]
+	zmin, zmax := math.Inf(1), math.Inf(-1)
+	for _, z := range zs {
+		if !math.IsNaN(z) && !math.IsInf(z, 0) {
+			zmin, zmax = min(zmin, z), max(zmax, z)
+		}
+	}
+	switch {
+	case zmin > zmax:
+		zmin, zmax = -1, 1 // nothing to draw
+	case zmin == zmax:
+		zmin, zmax = zmin-0.5, zmax+0.5
+	default:
+		pad := 0.05 * (zmax - zmin)
+		zmin, zmax = zmin-pad, zmax+pad
+	}
+	px := func(t float64) float64 { return sliceLeft + (t-tmin)/(tmax-tmin)*pw }
+	py := func(z float64) float64 { return sliceTop + (zmax-z)/(zmax-zmin)*ph }
+	coord := func(v float64) string { return string(appendCoord(nil, v, opts.Precision)) }
+
+	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' role='img' width='%d' height='%d' "+
+		"font-family='sans-serif' font-size='11'>", opts.Width, opts.Height)
+	svgMetadata(w, opts)
+	if c := opts.Background; c.A > 0 {
+		fmt.Fprintf(w, "<rect width='%d' height='%d' fill='#%02x%02x%02x' fill-opacity='%.3g'/>\n",
+			opts.Width, opts.Height, c.R, c.G, c.B, float64(c.A)/255)
+	}
+	fmt.Fprint(w, "<g stroke='#ddd'>")
+	for _, t := range ticks(tmin, tmax, 8) {
+		fmt.Fprintf(w, "<line x1='%s' y1='%g' x2='%s' y2='%g'/>", coord(px(t)), sliceTop, coord(px(t)), sliceTop+ph)
+	}
+	for _, z := range ticks(zmin, zmax, 6) {
+		fmt.Fprintf(w, "<line x1='%g' y1='%s' x2='%g' y2='%s'/>", sliceLeft, coord(py(z)), sliceLeft+pw, coord(py(z)))
+	}
+	fmt.Fprint(w, "</g>\n<g fill='black'>")
+	for _, t := range ticks(tmin, tmax, 8) {
+		fmt.Fprintf(w, "<text x='%s' y='%g' text-anchor='middle'>%s</text>", coord(px(t)), sliceTop+ph+14, tickLabel(t))
+	}
+	for _, z := range ticks(zmin, zmax, 6) {
+		fmt.Fprintf(w, "<text x='%g' y='%s' text-anchor='end' dominant-baseline='middle'>%s</text>", sliceLeft-6, coord(py(z)), tickLabel(z))
+	}
+	along := "x"
+	if opts.SliceAxis == "x" {
+		along = "y"
+	}
+	fmt.Fprintf(w, "<text x='%g' y='%g' text-anchor='middle'>%s</text>", sliceLeft+pw/2, height-6, along)
+	fmt.Fprintf(w, "<text x='14' y='%g' text-anchor='middle' dominant-baseline='middle'>z</text>", sliceTop+ph/2)
+	fmt.Fprintf(w, "<text x='%g' y='%g' text-anchor='end'>%s = %g</text></g>\n", sliceLeft+pw, sliceTop-8, opts.sliceAxis(), opts.SliceAt)
+	fmt.Fprintf(w, "<rect x='%g' y='%g' width='%g' height='%g' fill='none' stroke='grey'/>\n", sliceLeft, sliceTop, pw, ph)
+
+	c := opts.Stroke
+	buf := fmt.Appendf(nil, "<path fill='none' stroke='#%02x%02x%02x' stroke-width='%g' stroke-linejoin='round' d='",
+		c.R, c.G, c.B, max(2*opts.StrokeWidth, 1))
+	pen := false // whether the previous height was drawn
+	for k, z := range zs {
+		if math.IsNaN(z) || math.IsInf(z, 0) {
+			pen = false
+			continue
+		}
+		if pen {
+			buf = append(buf, 'L')
+		} else {
+			buf = append(buf, 'M')
+		}
+		buf = appendCoord(buf, px(ts[k]), opts.Precision)
+		buf = append(buf, ' ')
+		buf = appendCoord(buf, py(z), opts.Precision)
+		pen = true
+	}
+	buf = append(buf, "'/>\n"...)
+	if opts.Tooltips {
+		for k, z := range zs {
+			if math.IsNaN(z) || math.IsInf(z, 0) {
+				continue
+			}
+			buf = fmt.Appendf(buf, "<circle cx='%s' cy='%s' r='3' fill='#%02x%02x%02x' fill-opacity='0'><title>%s=%g z=%g</title></circle>\n",
+				coord(px(ts[k])), coord(py(z)), c.R, c.G, c.B, along, ts[k], z)
+		}
+	}
+	buf = append(buf, "</svg>\n"...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// sliceAxis returns the axis of the cut of o, "x" or "y".
+func (o Options) sliceAxis() string {
+	if o.SliceAxis == "" {
+		return "y"
+	}
+	return o.SliceAxis
+}
+
+// checkSlice reports whether the cut of o lies within its domain.
+func (o Options) checkSlice() error {
+	if _, ok := o.Projector.(ParametricProjector); ok {
+		return errors.New("surface: the slice View applies to height fields, not parametric surfaces")
+	}
+	xmin, xmax, ymin, ymax := o.Domain()
+	lo, hi := ymin, ymax
+	switch o.SliceAxis {
+	case "", "y":
+	case "x":
+		lo, hi = xmin, xmax
+	default:
+		return fmt.Errorf("surface: unknown SliceAxis %q", o.SliceAxis)
+	}
+	if !(o.SliceAt >= lo && o.SliceAt <= hi) {
+		return fmt.Errorf("surface: SliceAt %g is not in the domain %g..%g of %s", o.SliceAt, lo, hi, o.sliceAxis())
+	}
+	return nil
+}
+
+// sliceHeights samples the heights of opts along its cut at each grid line
+// across it, returning the coordinates of the samples along the cut and
+// their heights. The grid is shifted for its first line to lie on the cut,
+// except for surfaces sampled on a fixed grid already, such as heightmaps,
+// whose heights are interpolated between the lines on either side.
+func sliceHeights(opts Options) (ts, zs []float64) {
+	g := opts.grid()
+	alongX := opts.sliceAxis() == "y"
+	n, across, span := g.nx, g.ny, g.yspan
+	lo := g.yc - g.yspan/2
+	if !alongX {
+		n, across, span = g.ny, g.nx, g.xspan
+		lo = g.xc - g.xspan/2
+	}
+	corner := func(g Grid, k, l int) (t, z float64) {
+		if alongX {
+			t, _, z = opts.Projector.Corner(g, k, l)
+		} else {
+			_, t, z = opts.Projector.Corner(g, l, k)
+		}
+		return t, z
+	}
+	ts, zs = make([]float64, n+1), make([]float64, n+1)
+	switch opts.Projector.(type) {
+	case HeightmapProjector, sampled, filled:
+		f := (opts.SliceAt - lo) / span * float64(across)
+		l := min(max(int(f), 0), across-1)
+		u := f - float64(l)
+		for k := range ts {
+			t, z := corner(g, k, l)
+			if u > 0 {
+				_, z1 := corner(g, k, l+1)
+				z = (1-u)*z + u*z1
+			}
+			ts[k], zs[k] = t, z
+		}
+	default:
+		if alongX {
+			g.yc = opts.SliceAt + g.yspan/2
+		} else {
+			g.xc = opts.SliceAt + g.xspan/2
+		}
+		for k := range ts {
+			ts[k], zs[k] = corner(g, k, 0)
+		}
+	}
+	return ts, zs
+}
+
+// ticks returns about n round values from lo to hi for the ticks of an
+// axis.
+func ticks(lo, hi float64, n int) []float64 {
+	step := math.Pow(10, math.Floor(math.Log10((hi-lo)/float64(n))))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if (hi-lo)/(m*step) <= float64(n) {
+			step *= m
+			break
+		}
+	}
+	var vs []float64
+	for k := math.Ceil(lo / step); k*step <= hi; k++ {
+		vs = append(vs, k*step)
+	}
+	return vs
+}
+
+// tickLabel formats the tick v.
+func tickLabel(v float64) string {
+	if math.Abs(v) < 1e-12 {
+		v = 0
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
@@ -0,0 +1,101 @@
+package surface
+
+import (
+	"context"
+	"math"
+)
+
+const maxSmoothing = 50 // widest Smoothing radius, in cells
+
+// sampled is a surface sampled at the corners of a grid of cells, such as
+// to have its heights filtered. Corner ignores the grid it is given, which
+// must be the one sampled.
+type sampled struct {
+	ny      int       // cells along y
+	x, y, z []float64 // of corner (i,j) at i*(ny+1)+j
+}
+
+func (s sampled) Corner(g Grid, i, j int) (float64, float64, float64) {
+	k := i*(s.ny+1) + j
+	return s.x[k], s.y[k], s.z[k]
+}
+
+// sampleCorners samples the corners of the grid of opts.
+func sampleCorners(ctx context.Context, opts Options) (sampled, error) {
+	g, ni, nj := opts.grid(), opts.Cells+1, opts.cellsY()+1
+	s := sampled{ny: nj - 1, x: make([]float64, ni*nj), y: make([]float64, ni*nj), z: make([]float64, ni*nj)}
+	for i := range ni {
+		if err := ctx.Err(); err != nil {
+			return sampled{}, err
+		}
+		for j := range nj {
+			k := i*nj + j
+			s.x[k], s.y[k], s.z[k] = opts.Projector.Corner(g, i, j)
+		}
+	}
+	return s, nil
+}
+
+// smoothingBytes returns the memory to smooth the surface of a grid of
+// nx×ny cells.
+func smoothingBytes(nx, ny int) int64 {
+	return 4 * 8 * int64(nx+1) * int64(ny+1) // x, y, z and the intermediate heights
+}
+
+// smooth samples the corners of opts and blurs their heights with a
+// Gaussian filter reaching opts.Smoothing corners out. Heights that are NaN
+// or infinite stay so and are left out of the averages of their
+// neighbors.
+func smooth(ctx context.Context, opts Options) (sampled, error) {
+	defer opts.trace("smooth")()
+	s, err := sampleCorners(ctx, opts)
+	if err != nil {
+		return sampled{}, err
+	}
+	ni, nj := opts.Cells+1, opts.cellsY()+1
+
+	r := opts.Smoothing
+	sigma := float64(r) / 2
+	weights := make([]float64, r+1)
+	for d := range weights {
+		weights[d] = math.Exp(-float64(d*d) / (2 * sigma * sigma))
+	}
+	// The filter is separable: blur along j into tmp, then along i.
+	tmp := make([]float64, ni*nj)
+	blur := func(dst, src []float64, na, n int, at func(a, b int) int) error {
+		for a := range na {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for b := range n {
+				z := src[at(a, b)]
+				if math.IsNaN(z) || math.IsInf(z, 0) {
+					dst[at(a, b)] = z
+					continue
+				}
+				var sum, total float64
+				for c := max(0, b-r); c <= min(n-1, b+r); c++ {
+					z := src[at(a, c)]
+					if math.IsNaN(z) || math.IsInf(z, 0) {
+						continue
+					}
+					d := c - b
+					if d < 0 {
+						d = -d
+					}
+					sum += weights[d] * z
+					total += weights[d]
+				}
+				dst[at(a, b)] = sum / total
+			}
+		}
+		return nil
+	}
+	if err := blur(tmp, s.z, ni, nj, func(i, j int) int { return i*nj + j }); err != nil {
+		return sampled{}, err
+	}
+	if err := blur(s.z, tmp, nj, ni, func(j, i int) int { return i*nj + j }); err != nil {
+		return sampled{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,118 @@
+package surface
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// solid is the surface as a 3-D triangle mesh in function coordinates,
+// before rotation and projection. Each cell whose corners are all finite
+// becomes two triangles wound counter-clockwise seen from +z, so their
+// normals point up.
+type solid struct {
+	vertices  [][3]float64 // finite grid corners, with z scaled
+	heights   []float64    // unscaled z of each vertex, for coloring
+	triangles [][3]int     // indices into vertices
+}
+
+// newSolid samples the grid corners of opts. Heights are clamped and
+// mapped as for the projection and multiplied by opts.ZFactor.
+func newSolid(ctx context.Context, opts Options) (*solid, error) {
+	defer opts.trace("sample")()
+	g := opts.grid()
+	s := new(solid)
+	nx, ny := opts.Cells, opts.cellsY()
+	index := make([][]int, nx+1) // vertex of corner (i,j), or -1
+	for i := range index {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		index[i] = make([]int, ny+1)
+		for j := range index[i] {
+			x, y, z := opts.Projector.Corner(g, i, j)
+			if math.IsNaN(z) || math.IsInf(z, 0) {
+				index[i][j] = -1
+				continue
+			}
+			z = opts.mapZ(z)
+			index[i][j] = len(s.vertices)
+			s.vertices = append(s.vertices, [3]float64{x, y, z * opts.ZFactor})
+			s.heights = append(s.heights, z)
+		}
+	}
+	for i := 0; i < nx; i++ {
+		for j := 0; j < ny; j++ {
+			a, b, c, d := index[i][j], index[i+1][j], index[i+1][j+1], index[i][j+1]
+			if a < 0 || b < 0 || c < 0 || d < 0 {
+				continue
+			}
+			s.triangles = append(s.triangles, [3]int{a, b, c}, [3]int{a, c, d})
+		}
+	}
+	if opts.Stats != nil {
+		opts.Stats.Polygons += len(s.triangles) / 2
+	}
+	return s, nil
+}
+
+// normal returns the unit normal of triangle t.
+func (s *solid) normal(t [3]int) [3]float64 {
+	a, b, c := s.vertices[t[0]], s.vertices[t[1]], s.vertices[t[2]]
+	u := [3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}
+	v := [3]float64{c[0] - a[0], c[1] - a[1], c[2] - a[2]}
+	n := normalize(cross(u, v))
+	if math.IsNaN(n[0]) {
+		return [3]float64{0, 0, 1} // degenerate triangle
+	}
+	return n
+}
+
+// objMesh writes the surface for opts as a Wavefront OBJ file.
+func objMesh(ctx context.Context, w io.Writer, opts Options) error {
+	s, err := newSolid(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %d vertices, %d triangles\n", len(s.vertices), len(s.triangles))
+	for _, v := range s.vertices {
+		fmt.Fprintf(bw, "v %g %g %g\n", v[0], v[1], v[2])
+	}
+	for _, t := range s.triangles {
+		// OBJ indices start at 1.
+		fmt.Fprintf(bw, "f %d %d %d\n", t[0]+1, t[1]+1, t[2]+1)
+	}
+	return bw.Flush()
+}
+
+// stlMesh writes the surface for opts as a binary STL file.
+func stlMesh(ctx context.Context, w io.Writer, opts Options) error {
+	s, err := newSolid(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	bw := bufio.NewWriter(w)
+	var header [80]byte
+	copy(header[:], "surface")
+	bw.Write(header[:])
+	binary.Write(bw, binary.LittleEndian, uint32(len(s.triangles)))
+	var facet [12]float32
+	for _, t := range s.triangles {
+		n := s.normal(t)
+		for k := 0; k < 3; k++ {
+			facet[k] = float32(n[k])
+			for l := 0; l < 3; l++ {
+				facet[3+3*k+l] = float32(s.vertices[t[k]][l])
+			}
+		}
+		binary.Write(bw, binary.LittleEndian, facet)
+		bw.Write([]byte{0, 0}) // attribute byte count
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,157 @@
+package surface
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// eyeAngle is the azimuth in degrees between the views of the two eyes.
+const eyeAngle = 4.0
+
+// stereo reports whether o draws a view for each eye.
+func (o Options) stereo() bool {
+	return o.View == "stereo" || o.View == "anaglyph"
+}
+
+// eyes returns the options of the views of the left and right eyes of o,
+// turned eyeAngle/2 either way. Side by side, each takes half the canvas.
+// An anaglyph is drawn on Background, or white if it is transparent.
+func (o Options) eyes() [2]Options {
+	if o.View == "anaglyph" && o.Background.A < 255 {
+		o.Background = blend(color.RGBA{R: 255, G: 255, B: 255, A: 255}, opaque(o.Background), float64(o.Background.A)/255)
+	}
+	left, right := o, o
+	left.View, right.View = "surface", "surface"
+	// Turning the camera counter-clockwise moves it to the left.
+	left.Azimuth += eyeAngle / 2
+	right.Azimuth -= eyeAngle / 2
+	if o.View == "stereo" {
+		left.Width = o.Width / 2
+		right.Width = o.Width - left.Width
+	}
+	return [2]Options{left, right}
+}
+
+// sampleEyes samples the surface as each eye of opts sees it.
+func sampleEyes(ctx context.Context, opts Options) ([2]Options, [2]*mesh, error) {
+	eyes := opts.eyes()
+	var ms [2]*mesh
+	for k, eye := range eyes {
+		var err error
+		if ms[k], err = sample(ctx, eye); err != nil {
+			return eyes, ms, err
+		}
+	}
+	return eyes, ms, nil
+}
+
+// stereoSVG writes the views of both eyes of opts as an SVG, side by side
+// for the "stereo" View, or for the "anaglyph" View combined into one with
+// the gray of the left view in red and the green and blue of the right,
+// for red-cyan glasses.
+func stereoSVG(ctx context.Context, w io.Writer, opts Options) error {
+	eyes, ms, err := sampleEyes(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	anaglyph := opts.View == "anaglyph"
+	header := opts
+	header.Fit = false
+	if anaglyph {
+		header.Background = color.RGBA{} // drawn within each view
+	}
+	svgHeader(w, emptyBounds(), header)
+	if anaglyph {
+		fmt.Fprintf(w, "<filter id='left' filterUnits='userSpaceOnUse' x='0' y='0' width='%d' height='%d' color-interpolation-filters='sRGB'>"+
+			"<feColorMatrix values='0.299 0.587 0.114 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 1 0'/></filter>"+
+			"<filter id='right' filterUnits='userSpaceOnUse' x='0' y='0' width='%[1]d' height='%[2]d' color-interpolation-filters='sRGB'>"+
+			"<feColorMatrix values='0 0 0 0 0 0 1 0 0 0 0 0 1 0 0 0 0 0 1 0'/></filter>\n",
+			opts.Width, opts.Height)
+	}
+	x := 0
+	for k, eye := range eyes {
+		levels, err := contourLevels(ms[k].bounds, eye)
+		if err != nil {
+			return err
+		}
+		name := [2]string{"left", "right"}[k]
+		if anaglyph {
+			// The channels of the views are disjoint, so screening the right
+			// over the left adds them.
+			fmt.Fprintf(w, "<g filter='url(#%s)'", name)
+			if k > 0 {
+				fmt.Fprint(w, " style='mix-blend-mode: screen'")
+			}
+			c := eye.Background
+			fmt.Fprintf(w, "><rect width='%d' height='%d' fill='#%02x%02x%02x' stroke='none'/>", eye.Width, eye.Height, c.R, c.G, c.B)
+		}
+		var viewBox string
+		if eye.Fit {
+			viewBox = fmt.Sprintf(" viewBox='%s'", ms[k].bounds.viewBox(eye.Width, eye.Height, eye.Padding))
+		}
+		fmt.Fprintf(w, "<svg x='%d' width='%d' height='%d'%s>\n", x, eye.Width, eye.Height, viewBox)
+		if err := surface(ctx, w, ms[k], eye, name[:1]); err != nil {
+			return err
+		}
+		if err := contours(ctx, w, ms[k], levels, eye); err != nil {
+			return err
+		}
+		if eye.Legend {
+			legend(w, ms[k].bounds, eye)
+		}
+		fmt.Fprint(w, "</svg>")
+		if anaglyph {
+			fmt.Fprint(w, "</g>")
+		}
+		fmt.Fprintln(w)
+		if !anaglyph {
+			x += eye.Width
+		}
+	}
+	fmt.Fprint(w, "</svg>")
+	return nil
+}
+
+// stereoPNG rasterizes the views of both eyes of opts into a PNG, as
+// stereoSVG draws them.
+func stereoPNG(ctx context.Context, w io.Writer, opts Options) error {
+	eyes, ms, err := sampleEyes(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	imgs := [2]*image.RGBA{img, img}
+	if opts.View == "anaglyph" {
+		imgs[1] = image.NewRGBA(img.Rect)
+	} else {
+		imgs[0] = img.SubImage(image.Rect(0, 0, eyes[0].Width, opts.Height)).(*image.RGBA)
+		imgs[1] = img.SubImage(image.Rect(eyes[0].Width, 0, opts.Width, opts.Height)).(*image.RGBA)
+	}
+	for k, eye := range eyes {
+		levels, err := contourLevels(ms[k].bounds, eye)
+		if err != nil {
+			return err
+		}
+		fillBackground(imgs[k], eye)
+		tr := newTransform(ms[k].bounds, eye)
+		tr.dx += float64(imgs[k].Rect.Min.X)
+		if err := rasterize(ctx, imgs[k], ms[k], levels, tr, eye); err != nil {
+			return err
+		}
+	}
+	if opts.View == "anaglyph" {
+		right := imgs[1].Pix
+		for i := 0; i < len(img.Pix); i += 4 {
+			p := img.Pix[i : i+4 : i+4]
+			p[0] = uint8(0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2]) + 0.5)
+			p[1], p[2], p[3] = right[i+1], right[i+2], 255
+		}
+	}
+	return png.Encode(w, img)
+}
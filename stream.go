@@ -0,0 +1,130 @@
+package surface
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+)
+
+// streamSVG writes the SVG of opts without keeping the sampled mesh. A
+// first pass over the grid finds the range of heights and the projected
+// extents; a second computes each cell again and writes it at once, in the
+// grid order that paints an orthographic view from back to front, rather
+// than sorting the cells by depth. Contours take a third pass.
+func streamSVG(ctx context.Context, w io.Writer, opts Options) error {
+	b, err := sweep(ctx, opts, nil)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	levels, err := contourLevels(b, opts)
+	if err != nil {
+		return err
+	}
+	b.vmin, b.vmax = opts.colorRange(b.vmin, b.vmax, nil)
+	m := &mesh{bounds: b} // the bounds alone, for the colors of the cells
+
+	svgHeader(w, b, opts)
+	bp := cellBuffers.Get().(*[]byte)
+	defer cellBuffers.Put(bp)
+	buf := (*bp)[:0]
+	var mg *merger
+	if opts.merged() {
+		mg = new(merger)
+	}
+	polygons := 0
+	err = streamCells(ctx, opts, func(i, j int, p polygon) {
+		if !p.valid {
+			return
+		}
+		if mg != nil {
+			buf = mg.add(buf, m, p, opts)
+		} else {
+			buf = m.appendCell(buf, p, i, j, opts, "")
+		}
+		if len(buf) >= flushSize {
+			w.Write(buf)
+			buf = buf[:0]
+		}
+		polygons++
+	})
+	if err != nil {
+		return err
+	}
+	if mg != nil {
+		buf = mg.flush(buf)
+	}
+	w.Write(buf)
+	*bp = buf
+	if opts.Stats != nil {
+		opts.Stats.Polygons, opts.Stats.ZMin, opts.Stats.ZMax = polygons, b.zmin, b.zmax
+	}
+
+	if len(levels) > 0 {
+		paths := make([][]byte, len(levels))
+		err = streamCells(ctx, opts, func(i, j int, p polygon) {
+			if !p.valid {
+				return
+			}
+			for k, level := range levels {
+				paths[k] = p.appendContour(paths[k], level, opts.Precision)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		c := opts.ContourColor
+		for k, d := range paths {
+			if len(d) > 0 {
+				fmt.Fprintf(w, "<path d='%s' fill='none' stroke='#%02x%02x%02x' stroke-width='%g'><title>z=%g</title></path>\n",
+					d, c.R, c.G, c.B, opts.ContourWidth, levels[k])
+			}
+		}
+	}
+	if opts.Legend {
+		legend(w, b, opts)
+	}
+	fmt.Fprint(w, "</svg>")
+	return nil
+}
+
+// streamCells computes every cell of the surface in turn and passes it to
+// visit, starting from the corner of the grid furthest from the viewer.
+func streamCells(ctx context.Context, opts Options, visit func(i, j int, p polygon)) error {
+	sin, cos := math.Sincos(opts.Rotate * math.Pi / 180)
+	g, pr := opts.grid(), opts.projection()
+	// How the depth changes along the rotated i and j axes.
+	di := -(cos*pr.back[0] + sin*pr.back[1])
+	dj := -(-sin*pr.back[0] + cos*pr.back[1])
+	b := emptyBounds()
+	ny := opts.cellsY()
+	for n := 0; n < opts.Cells; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i := n
+		if di > 0 {
+			i = opts.Cells - 1 - n
+		}
+		for k := 0; k < ny; k++ {
+			j := k
+			if dj > 0 {
+				j = ny - 1 - k
+			}
+			if opts.Mesh != "tri" {
+				visit(i, j, cell(opts, g, pr, i, j, sin, cos, &b))
+				continue
+			}
+			// The further triangle first.
+			t := cellTris(opts, g, pr, i, j, i+1, j+1, sin, cos, &b)
+			near := 0
+			if t[1].depth < t[0].depth {
+				near = 1
+			}
+			visit(i, 2*j+1-near, t[1-near])
+			visit(i, 2*j+near, t[near])
+		}
+	}
+	return nil
+}
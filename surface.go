@@ -1,160 +1,983 @@
-// Surface computes an SVG rendering of a 3-D surface function.
-package main
+// Package surface computes an SVG rendering of a 3-D surface function.
+package surface
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image/color"
 	"io"
-	"log"
 	"math"
-	"net/http"
+	"sort"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	width, height = 600, 320            // canvas size in pixels
-	cells         = 100                 // number of grid cells
-	xyrange       = 30.0                // axis ranges (-xyrange..+xyrange)
-	xyscale       = width / 2 / xyrange // pixels per x or y unit
-	zscale        = height * 0.4        // pixels per z unit
-	angle         = math.Pi / 6         // angle of x, y axes (=30°)
+	width, height = 600, 320    // default canvas size in pixels
+	cells         = 100         // default number of grid cells per side
+	xyrange       = 30.0        // default axis ranges (-xyrange/2..+xyrange/2)
+	angle         = math.Pi / 6 // angle of x, y axes (=30°)
 )
 
 var sin30, cos30 = math.Sin(angle), math.Cos(angle) // sin(30°), cos(30°)
 
-func main() {
-	http.HandleFunc("/", handler) // eapeakColor request calls handler
-	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+var grey = color.RGBA{R: 128, G: 128, B: 128, A: 255} // default outline color
+
+// Options controls how a surface is rendered. Zero fields fall back to the
+// values documented on each field; DefaultOptions returns the defaults used
+// by the HTTP server.
+type Options struct {
+	Projector Projector // surface function; nil means SinProjector
+	Width     int       // canvas width in pixels; 0 means 600
+	Height    int       // canvas height in pixels; 0 means 320
+	XYRange   float64   // axis ranges (-XYRange/2..+XYRange/2); 0 means 30
+	// XMin..XMax and YMin..YMax, if not empty, replace the x and y ranges
+	// of XYRange. The projection scale fits the longer of the two.
+	XMin, XMax float64
+	YMin, YMax float64
+	Cells      int          // grid cells per side, or along x if CellsY is set; 0 means 100
+	CellsY     int          // grid cells along y; 0 means Cells
+	Stops      []color.RGBA // gradient from the lowest to the highest z, whose alpha is the fill opacity; nil means white
+	Offsets    []float64    // increasing positions of Stops from 0 at the lowest to 1 at the highest z; nil spaces them evenly
+	Format     string       // "svg", "json", "png", "gif", "pdf", "obj", "stl" or "gltf"; empty means "svg"
+	Shading    bool         // modulate fills by the lighting of each cell
+	// Diverging centers the gradient on the height Center instead of the
+	// middle of the range, so that heights the same distance above and
+	// below it take colors the same distance from the middle of Stops.
+	Diverging bool
+	Center    float64
+	// Bands, if positive, quantizes the gradient into that many bands of a
+	// single color each, like filled contours.
+	Bands int
+	// Stream writes a still SVG as its cells are computed instead of
+	// sampling the whole grid first, in constant memory. Its cells are
+	// drawn in grid order, which is back to front for orthographic views
+	// only.
+	Stream bool
+	// Merge draws each run of consecutive cells of the same fill as a
+	// single SVG path, unless cells have tooltips or gradients. It pays off
+	// with Bands and Wireframe, where many cells share a fill.
+	Merge bool
+	// Legend draws a color bar labelled with heights beside an SVG.
+	Legend bool
+	// Cull leaves out the cells that face away from the viewer or that
+	// nearer cells hide entirely, which shrinks the output of dense grids.
+	Cull bool
+	// Gradient fills each SVG cell with a linear gradient from the color of
+	// its lowest corner to that of its highest, instead of a flat color.
+	Gradient bool
+	// ColorBy is the value of each cell on the color ramp: "height", the
+	// default, or "slope", the magnitude of the gradient of a height field,
+	// which makes steep regions stand out.
+	ColorBy string
+	// ColorMin..ColorMax, if not empty, is the fixed range of the color
+	// ramp, in heights before ZMap or in slopes by ColorBy, so that the
+	// colors of renders are comparable; values beyond it take the colors
+	// of its ends. Otherwise Percentiles, if not zero, are the percentiles
+	// from 0 to 100 of the values of the cells that the ramp spans, so that
+	// a few outliers cannot stretch it. Stream supports the range only.
+	ColorMin, ColorMax float64
+	Percentiles        [2]float64
+	// Adaptive samples a quadtree of cells in place of the uniform grid of
+	// an svg, png, gif or pdf render: blocks of 2^Refine cells per side,
+	// split while the surface bends away from them by more than Adaptive
+	// times the range of its heights, down to single cells. Zero Adaptive
+	// samples every cell; zero Refine means 3.
+	Adaptive float64
+	Refine   int
+	// Mesh is "quad" or "tri"; empty means "quad". Tri draws each cell of an
+	// svg, png, gif or pdf render as the two triangles either side of its
+	// diagonal, each with its own depth, shading and color, which follow the
+	// corners of a cell that are not coplanar where one quad cannot.
+	Mesh string
+	// Smoothing blurs the heights with a Gaussian filter reaching this many
+	// cells out, before they are drawn, to soften noisy data. It freezes a
+	// TimeProjector at Time and leaves parametric surfaces as they are.
+	Smoothing int
+	// Holes is what becomes of the cells with a NaN or infinite corner,
+	// such as at the singularity of sin(r)/r: "skip", the default, leaves
+	// them out, "interpolate" fills the heights in from their neighbors,
+	// and "fill" does too but colors the cells HoleColor, zero meaning
+	// grey, in svg, png, gif and pdf renders. It applies after Smoothing
+	// and leaves parametric surfaces as they are.
+	Holes     string
+	HoleColor color.RGBA
+	// Layers are more surfaces over the domain of Projector, drawn in the
+	// same projection with their cells sorted by depth together with its
+	// own, in svg, png, gif and pdf renders. Their heights share the color
+	// range. Smoothing, Holes and Time apply to Projector only.
+	Layers []Layer
+	// Light is the azimuth, counter-clockwise from the +x axis, and the
+	// elevation in degrees of the light for Shading; zero means 180, 45.
+	Light [2]float64
+	// Fit scales the surface to fill the canvas, leaving Padding pixels
+	// around it; zero Padding leaves 2% of the larger extent of the surface.
+	Fit     bool
+	Padding float64
+	// Tooltips adds to each cell data-x, data-y and data-z attributes with
+	// the middle and height of the cell, for scripts, and a <title> with
+	// them, shown on hover. It more than doubles the size of the SVG.
+	Tooltips bool
+	// Title and Desc are the accessible name and description of an SVG,
+	// written as its <title> and <desc>.
+	Title, Desc string
+	// Metadata, if set, is written into an SVG as Dublin Core.
+	Metadata *Metadata
+	Rotate   float64 // rotation about the z axis in degrees
+	// Azimuth turns the camera counter-clockwise about the z axis by that
+	// many degrees from the default view. Elevation is the angle in degrees
+	// of the camera above the xy plane, in (0, 90]; 0 means the 35.26° of
+	// the isometric view. Zoom magnifies the canvas; 0 means 1.
+	Azimuth, Elevation, Zoom float64
+	// Projection is "orthographic" or "perspective"; empty means
+	// "orthographic". A perspective camera FOV degrees wide, 0 meaning 60,
+	// looks at the center of the domain from Distance units away; 0 means
+	// twice the longer side of the domain.
+	Projection    string
+	FOV, Distance float64
+	// View is "surface", "heatmap", "slice", "stereo" or "anaglyph"; empty
+	// means "surface". A heatmap draws the cells from above as a flat grid
+	// filling the canvas, with x to the right and y upwards, and without
+	// outlines unless Wireframe is set. A slice is an SVG line chart of the
+	// heights along the cut where the axis SliceAxis, "x" or "y" with empty
+	// meaning "y", is SliceAt, sampled at every grid line across it. Stereo
+	// draws the surface for the left and right eyes side by side for
+	// parallel viewing, and anaglyph combines the two views for red-cyan
+	// glasses; both are SVG or PNG.
+	View      string
+	SliceAxis string
+	SliceAt   float64
+	// Background fills the canvas behind the surface; the zero value
+	// leaves it transparent.
+	Background color.RGBA
+	// Wireframe draws only the cell outlines, leaving them unfilled.
+	Wireframe bool
+	// Stroke, StrokeWidth and StrokeOpacity style the cell outlines; zero
+	// values mean grey, 0.7 pixels and opaque.
+	Stroke        color.RGBA
+	StrokeWidth   float64
+	StrokeOpacity float64
+	// FillOpacity is the opacity of the cell fills, multiplied by the alpha
+	// of the colors of Stops; zero means opaque.
+	FillOpacity float64
+	// Scale and ZScale are the pixels per unit of x or y and of z before
+	// Zoom; zero means fitting the domain across the width of the canvas and
+	// 0.4 of its height per unit of z. A perspective camera only follows
+	// their ratio, as an exaggeration of the heights.
+	Scale  float64
+	ZScale float64
+	// ZClamp, if positive, caps |z| so that a single spike cannot dominate
+	// the projection and the color scale. ZClampMin..ZClampMax, if not
+	// empty, clamps z to that range.
+	ZClamp               float64
+	ZClampMin, ZClampMax float64
+	// ZMap transforms the clamped heights before projection and coloring:
+	// "log" to sign(z)·log(1+|z|) and "sqrt" to sign(z)·√|z|, so that the
+	// low parts of functions of a large dynamic range stay visible; empty
+	// or "linear" leaves them be. Contours are evenly spaced in the mapped
+	// heights, while the legend and tooltips show the heights of the
+	// function.
+	ZMap      string
+	FlipY     bool // mirror the canvas so its y axis grows upwards
+	Precision int  // decimal places of the emitted coordinates
+	// Contours, if positive, draws contour lines over the surface at every
+	// multiple of Contours; otherwise ContourLevels, if positive, draws
+	// that many at evenly spaced heights between the lowest and the
+	// highest. They are ContourColor and ContourWidth pixels wide.
+	Contours      float64
+	ContourLevels int
+	ContourColor  color.RGBA
+	ContourWidth  float64 // 0 means 0.5
+	// Animate loops through views rotating about the z axis or, if the
+	// Projector is a TimeProjector, moves the cells through one loop of t.
+	Animate bool
+	// Time is the moment t, in [0, 1), at which a TimeProjector is sampled
+	// by renders that do not animate it.
+	Time     float64
+	Duration time.Duration // length of one loop of an animation; 0 means 12s
+	Frames   int           // views per loop of a rotating GIF; 0 means 36
+	// PageWidth and PageHeight are the size in points of the PDF page; 0
+	// means the canvas size plus the margins. The surface is scaled to fit
+	// inside Margin points of each edge.
+	PageWidth, PageHeight float64
+	Margin                float64
+	// ZFactor multiplies the heights of the 3-D meshes of formats obj, stl
+	// and gltf; 0 means 1.
+	ZFactor float64
+	Stats   *Stats // if not nil, collects statistics of the render
+	// Trace, if not nil, is called as each stage of the render starts:
+	// "sample", computing and projecting the cells, "sort", ordering them
+	// by depth, and "encode", writing the output. It returns the function
+	// to call as the stage ends. Animations sample once per frame.
+	Trace func(stage string) (end func())
+}
+
+// Stats summarizes a completed render.
+type Stats struct {
+	Polygons   int     // cells sampled without NaN or Inf corners
+	Culled     int     // of those, cells dropped by Cull
+	ZMin, ZMax float64 // range of the heights of those cells
+}
+
+// DefaultOptions returns the options of a request without parameters.
+func DefaultOptions() Options {
+	return Options{
+		Projector:    SinProjector{},
+		Width:        width,
+		Height:       height,
+		XYRange:      xyrange,
+		Cells:        cells,
+		Stops:        []color.RGBA{{R: 255, G: 255, B: 255, A: 255}},
+		Precision:    2,
+		ContourWidth: 0.5,
+		Stroke:       grey,
+		StrokeWidth:  0.7,
+		Duration:     12 * time.Second,
+		ZFactor:      1,
+	}
+}
+
+// withDefaults fills in the zero fields of o that have a default.
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.Projector == nil {
+		o.Projector = d.Projector
+	}
+	if o.Width == 0 {
+		o.Width = d.Width
+	}
+	if o.Height == 0 {
+		o.Height = d.Height
+	}
+	if o.XYRange == 0 {
+		o.XYRange = d.XYRange
+	}
+	if o.Cells == 0 {
+		o.Cells = d.Cells
+	}
+	if len(o.Stops) == 0 {
+		o.Stops = d.Stops
+	}
+	if o.Duration == 0 {
+		o.Duration = d.Duration
+	}
+	if o.ZFactor == 0 {
+		o.ZFactor = d.ZFactor
+	}
+	if o.Stroke == (color.RGBA{}) {
+		o.Stroke = d.Stroke
+	}
+	if o.StrokeWidth == 0 {
+		o.StrokeWidth = d.StrokeWidth
+	}
+	if o.StrokeOpacity == 0 {
+		o.StrokeOpacity = 1
+	}
+	if o.FillOpacity == 0 {
+		o.FillOpacity = 1
+	}
+	if o.ContourWidth == 0 {
+		o.ContourWidth = d.ContourWidth
+	}
+	return o
+}
+
+func (o Options) grid() Grid {
+	xmin, xmax, ymin, ymax := o.Domain()
+	if p, ok := o.Projector.(ParametricProjector); ok {
+		xmin, xmax, ymin, ymax = p.Domain()
+	}
+	return Grid{
+		xc: (xmin + xmax) / 2, yc: (ymin + ymax) / 2,
+		xspan: xmax - xmin, yspan: ymax - ymin,
+		nx: o.Cells, ny: o.cellsY(),
+	}
+}
+
+// cellsY returns the number of grid cells along y.
+func (o Options) cellsY() int {
+	if o.CellsY > 0 {
+		return o.CellsY
+	}
+	return o.Cells
 }
 
-// handler epeakColoroes the Path component of the request URL r.
-func handler(w http.ResponseWriter, r *http.Request) {
-	var err error
-	var projector Projector = SinProjector{}
-	height, width := height, width
-	peakColor := color.RGBA{R: 255, G: 255, B: 255, A: 255}
-	valleyColor := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+// Domain returns the ranges of x and y sampled by a render with o.
+func (o Options) Domain() (xmin, xmax, ymin, ymax float64) {
+	xmin, xmax = -o.XYRange/2, o.XYRange/2
+	ymin, ymax = xmin, xmax
+	if o.XMin < o.XMax {
+		xmin, xmax = o.XMin, o.XMax
+	}
+	if o.YMin < o.YMax {
+		ymin, ymax = o.YMin, o.YMax
+	}
+	return xmin, xmax, ymin, ymax
+}
 
-	if projectorStr := r.URL.Query().Get("function"); projectorStr != "" {
-		switch projectorStr {
-		case "sin":
-		case "eggbox":
-			projector = EggboxProjector{}
-		case "moguls":
-			projector = MogulsProjector{}
-		case "saddle":
-			projector = SaddleProjector{}
-		default:
-			http.Error(w, errorf("error: unknown value 'function'=%q", projectorStr), http.StatusBadRequest)
-			return
+func (o Options) projection() projection {
+	g := o.grid()
+	span := max(g.xspan, g.yspan)
+	p, parametric := o.Projector.(ParametricProjector)
+	if parametric {
+		span = 2 * p.Extent()
+	}
+	pr := newProjection(o.Width, o.Height, span)
+	if parametric {
+		// To scale on all three axes, and fitted to the shorter side; the
+		// default view draws horizontal lengths √2·cos30° times xyscale.
+		h := math.Sqrt2 * cos30
+		xyscale := 0.45 * float64(min(o.Width, o.Height)) / p.Extent() / h
+		pr.rescale(xyscale, xyscale*h*math.Cos(isoElevation*math.Pi/180))
+		pr.twoSided = true
+	}
+	if o.Scale > 0 || o.ZScale > 0 {
+		xyscale, zscale := pr.xyscale, pr.zscale
+		if o.Scale > 0 {
+			xyscale = o.Scale
+		}
+		if o.ZScale > 0 {
+			zscale = o.ZScale
 		}
+		pr.rescale(xyscale, zscale)
+	}
+	pr.flipy = o.FlipY
+	light := o.Light
+	if light == ([2]float64{}) {
+		light = defaultLight
+	}
+	pr.light = lightVector(light[0], light[1])
+	if o.View == "heatmap" {
+		if parametric {
+			pr.flatten(o.Width, o.Height, span, span)
+		} else {
+			pr.flatten(o.Width, o.Height, g.xspan, g.yspan)
+		}
+		return pr
+	}
+	elevation, zoom := o.Elevation, o.Zoom
+	if elevation == 0 {
+		elevation = isoElevation
+	}
+	if zoom == 0 {
+		zoom = 1
+	}
+	if o.Projection == "perspective" {
+		fov, distance := o.FOV, o.Distance
+		if fov == 0 {
+			fov = 60
+		}
+		if distance == 0 {
+			distance = 2 * span
+		}
+		pr.perspective(o.Azimuth, elevation, zoom, fov, distance)
+	} else if o.Azimuth != 0 || o.Elevation != 0 || o.Zoom != 0 {
+		pr.orbit(o.Azimuth, elevation, zoom)
+	}
+	return pr
+}
 
+// MeshBytes returns the memory a render with o needs for its sampled
+// meshes and raster frames, so callers can refuse renders that are too
+// large.
+func (o Options) MeshBytes() int64 {
+	o = o.withDefaults()
+	var smoothing int64
+	if o.Smoothing > 0 || o.Holes == "interpolate" || o.Holes == "fill" {
+		smoothing = smoothingBytes(o.Cells, o.cellsY())
 	}
-	if heightStr := r.URL.Query().Get("height"); heightStr != "" {
-		height, err = strconv.Atoi(heightStr)
-		if err != nil {
-			http.Error(w, errorf("cannot parse 'height'=%q to float", height), http.StatusBadRequest)
-			return
+	return smoothing + o.renderBytes()
+}
+
+// renderBytes is MeshBytes but for the heights of Smoothing.
+func (o Options) renderBytes() int64 {
+	pixels := int64(o.Width) * int64(o.Height)
+	mesh := meshBytes(o.Cells, o.faces()*o.cellsY()) * int64(1+len(o.Layers))
+	if o.stereo() {
+		mesh *= 2 // both eyes are sampled before either is drawn
+	}
+	var cull int64
+	if o.Cull {
+		cull = 4 * pixels // buffer of cell numbers, while sampling
+	}
+
+	switch o.Format {
+	case "", "svg":
+		if o.Stream {
+			return 0
+		}
+		if o.Animate {
+			return animationFrames*mesh + cull
+		}
+	case "png":
+		if o.View == "anaglyph" {
+			return mesh + max(8*pixels, cull) // an image for each eye
 		}
+		return mesh + max(4*pixels, cull)
+	case "gif":
+		// Every view is sampled before the first is drawn, then kept as
+		// one byte per pixel, with a single RGBA canvas to draw on.
+		frames := int64(o.gifFrameCount())
+		return frames*(mesh+pixels) + max(4*pixels, cull)
 	}
-	if widthStr := r.URL.Query().Get("width"); widthStr != "" {
-		width, err = strconv.Atoi(widthStr)
-		if err != nil {
-			http.Error(w, errorf("cannot parse 'width' %q to float", width), http.StatusBadRequest)
-			return
+	return mesh + cull
+}
+
+// Render writes the surface described by opts to w.
+func Render(w io.Writer, opts Options) error {
+	return RenderContext(context.Background(), w, opts)
+}
+
+// RenderContext is like Render but stops early, returning the context's
+// error, if ctx is cancelled during the render. The output is then
+// incomplete.
+func RenderContext(ctx context.Context, w io.Writer, opts Options) error {
+	opts = opts.withDefaults()
+	if !(opts.XYRange > 0) || math.IsInf(opts.XYRange, 0) {
+		return fmt.Errorf("surface: XYRange %g is not a positive number", opts.XYRange)
+	}
+	for _, v := range []float64{opts.XMin, opts.XMax, opts.YMin, opts.YMax} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("surface: domain %g..%g × %g..%g is not finite", opts.XMin, opts.XMax, opts.YMin, opts.YMax)
 		}
 	}
-	if colorStr := r.URL.Query().Get("valley"); colorStr != "" {
-		valleyColor, err = hexToRGBA(colorStr)
-		if err != nil {
-			http.Error(w, errorf("cannot parse 'valley' %q to RGBA", err), http.StatusBadRequest)
-			return
+	if opts.Elevation < 0 || opts.Elevation > 90 || opts.Zoom < 0 {
+		return fmt.Errorf("surface: Elevation %g is not in (0, 90] or Zoom %g is negative", opts.Elevation, opts.Zoom)
+	}
+	switch opts.View {
+	case "", "surface", "heatmap", "stereo", "anaglyph":
+	case "slice":
+		if err := opts.checkSlice(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("surface: unknown View %q", opts.View)
+	}
+	switch opts.Projection {
+	case "", "orthographic", "perspective":
+	default:
+		return fmt.Errorf("surface: unknown Projection %q", opts.Projection)
+	}
+	switch opts.ZMap {
+	case "", "linear", "log", "sqrt":
+	default:
+		return fmt.Errorf("surface: unknown ZMap %q", opts.ZMap)
+	}
+	if math.IsNaN(opts.ZClampMin) || math.IsInf(opts.ZClampMin, 0) || math.IsNaN(opts.ZClampMax) || math.IsInf(opts.ZClampMax, 0) {
+		return fmt.Errorf("surface: ZClampMin %g or ZClampMax %g is not finite", opts.ZClampMin, opts.ZClampMax)
+	}
+	switch opts.ColorBy {
+	case "", "height", "slope":
+	default:
+		return fmt.Errorf("surface: unknown ColorBy %q", opts.ColorBy)
+	}
+	if opts.FOV < 0 || opts.FOV >= 180 || opts.Distance < 0 {
+		return fmt.Errorf("surface: FOV %g is not in (0, 180) or Distance %g is negative", opts.FOV, opts.Distance)
+	}
+	if opts.StrokeWidth < 0 || opts.StrokeOpacity < 0 || opts.StrokeOpacity > 1 {
+		return fmt.Errorf("surface: StrokeWidth %g is negative or StrokeOpacity %g is not in [0, 1]", opts.StrokeWidth, opts.StrokeOpacity)
+	}
+	if opts.FillOpacity < 0 || opts.FillOpacity > 1 {
+		return fmt.Errorf("surface: FillOpacity %g is not in [0, 1]", opts.FillOpacity)
+	}
+	if opts.Cells < 1 || opts.CellsY < 0 {
+		return fmt.Errorf("surface: Cells %d is not positive or CellsY %d is negative", opts.Cells, opts.CellsY)
+	}
+	if opts.Padding < 0 || 2*opts.Padding >= float64(min(opts.Width, opts.Height)) {
+		return fmt.Errorf("surface: Padding %g is negative or leaves no room on the canvas", opts.Padding)
+	}
+	if opts.Scale < 0 || opts.ZScale < 0 {
+		return fmt.Errorf("surface: Scale %g or ZScale %g is negative", opts.Scale, opts.ZScale)
+	}
+	if opts.Bands < 0 {
+		return fmt.Errorf("surface: Bands %d is negative", opts.Bands)
+	}
+	if !(opts.Time >= 0 && opts.Time < 1) {
+		return fmt.Errorf("surface: Time %g is not in [0, 1)", opts.Time)
+	}
+	if tp, ok := opts.Projector.(TimeProjector); ok && opts.Time != 0 && !opts.Animate {
+		opts.Projector = atTime{tp, opts.Time}
+	}
+	if opts.Adaptive < 0 || opts.Refine < 0 {
+		return fmt.Errorf("surface: Adaptive %g or Refine %d is negative", opts.Adaptive, opts.Refine)
+	}
+	if opts.Adaptive > 0 {
+		_, timed := opts.Projector.(TimeProjector)
+		switch {
+		case opts.Format != "" && opts.Format != "svg" && opts.Format != "png" && opts.Format != "gif" && opts.Format != "pdf":
+			return fmt.Errorf("surface: Adaptive does not apply to format %q", opts.Format)
+		case opts.Stream:
+			return errors.New("surface: Stream does not support Adaptive")
+		case opts.Animate && timed:
+			return errors.New("surface: Adaptive cannot animate a TimeProjector")
+		}
+	}
+	switch opts.Mesh {
+	case "", "quad":
+	case "tri":
+		if opts.Format != "" && opts.Format != "svg" && opts.Format != "png" && opts.Format != "gif" && opts.Format != "pdf" {
+			return fmt.Errorf("surface: Mesh \"tri\" does not apply to format %q", opts.Format)
 		}
+	default:
+		return fmt.Errorf("surface: unknown Mesh %q", opts.Mesh)
 	}
-	if colorStr := r.URL.Query().Get("peak"); colorStr != "" {
-		peakColor, err = hexToRGBA(colorStr)
+	if math.IsNaN(opts.ColorMin) || math.IsInf(opts.ColorMin, 0) || math.IsNaN(opts.ColorMax) || math.IsInf(opts.ColorMax, 0) {
+		return fmt.Errorf("surface: ColorMin %g or ColorMax %g is not finite", opts.ColorMin, opts.ColorMax)
+	}
+	if p := opts.Percentiles; p != ([2]float64{}) {
+		if !(p[0] >= 0 && p[0] < p[1] && p[1] <= 100) {
+			return fmt.Errorf("surface: Percentiles %g..%g are not increasing in [0, 100]", p[0], p[1])
+		}
+		if opts.Stream {
+			return errors.New("surface: Stream does not support Percentiles")
+		}
+	}
+	if opts.Smoothing < 0 {
+		return fmt.Errorf("surface: Smoothing %d is negative", opts.Smoothing)
+	}
+	if _, ok := opts.Projector.(ParametricProjector); opts.Smoothing > 0 && !ok {
+		s, err := smooth(ctx, opts)
 		if err != nil {
-			http.Error(w, errorf("cannot parse 'peak' %q to RGBA", colorStr), http.StatusBadRequest)
-			return
+			return err
+		}
+		opts.Projector = s
+	}
+	switch opts.Holes {
+	case "", "skip":
+	case "interpolate", "fill":
+		if _, ok := opts.Projector.(ParametricProjector); !ok {
+			f, err := fillHoles(ctx, opts)
+			if err != nil {
+				return err
+			}
+			opts.Projector = f
 		}
+	default:
+		return fmt.Errorf("surface: unknown Holes %q", opts.Holes)
+	}
+	if opts.Offsets != nil {
+		if len(opts.Offsets) != len(opts.Stops) {
+			return fmt.Errorf("surface: %d Offsets for %d Stops", len(opts.Offsets), len(opts.Stops))
+		}
+		for k, x := range opts.Offsets {
+			if !(x >= 0 && x <= 1) || k > 0 && x < opts.Offsets[k-1] {
+				return fmt.Errorf("surface: Offsets %v are not increasing in [0, 1]", opts.Offsets)
+			}
+		}
+	}
+	if opts.Width < 0 || opts.Height < 0 {
+		return fmt.Errorf("surface: negative canvas size %d×%d", opts.Width, opts.Height)
+	}
+	if opts.Precision < 0 {
+		return errors.New("surface: negative Precision")
 	}
+	if opts.PageWidth < 0 || opts.PageHeight < 0 || opts.Margin < 0 {
+		return fmt.Errorf("surface: negative page size %g×%g or Margin %g", opts.PageWidth, opts.PageHeight, opts.Margin)
+	}
+	if len(opts.Layers) > 0 {
+		_, timed := opts.Projector.(TimeProjector)
+		_, parametric := opts.Projector.(ParametricProjector)
+		switch {
+		case len(opts.Layers) > maxLayers:
+			return fmt.Errorf("surface: %d Layers, more than %d", len(opts.Layers), maxLayers)
+		case opts.Format != "" && opts.Format != "svg" && opts.Format != "png" && opts.Format != "gif" && opts.Format != "pdf":
+			return fmt.Errorf("surface: Layers do not apply to format %q", opts.Format)
+		case opts.Stream || opts.View == "slice" || opts.Animate && timed:
+			return errors.New("surface: Layers need a still or rotating view of the whole surface")
+		case parametric:
+			return errors.New("surface: Layers apply to height fields, not parametric surfaces")
+		}
+		for k, l := range opts.Layers {
+			if _, ok := l.Projector.(ParametricProjector); ok || l.Projector == nil {
+				return fmt.Errorf("surface: Layer %d is not a height field", k)
+			}
+			if !(l.Opacity >= 0 && l.Opacity <= 1) {
+				return fmt.Errorf("surface: Opacity %g of Layer %d is not in [0, 1]", l.Opacity, k)
+			}
+		}
+		opts = opts.withLayerColors()
+	}
+	if opts.View == "slice" {
+		if opts.Format != "" && opts.Format != "svg" || opts.Animate || opts.Stream {
+			return errors.New("surface: the slice View is a still SVG only")
+		}
+		return sliceSVG(ctx, w, opts)
+	}
+	if opts.stereo() {
+		switch {
+		case opts.Animate || opts.Stream:
+			return fmt.Errorf("surface: the %s View is a still SVG or PNG only", opts.View)
+		case opts.Format == "" || opts.Format == "svg":
+			return stereoSVG(ctx, w, opts)
+		case opts.Format == "png":
+			return stereoPNG(ctx, w, opts)
+		}
+		return fmt.Errorf("surface: the %s View is a still SVG or PNG only", opts.View)
+	}
+	switch opts.Format {
+	case "", "svg":
+		if opts.Stream {
+			if opts.Animate || opts.Cull {
+				return errors.New("surface: Stream does not support Animate or Cull")
+			}
+			return streamSVG(ctx, w, opts)
+		}
+		if tp, ok := opts.Projector.(TimeProjector); ok && opts.Animate {
+			return timeSVG(ctx, w, opts, tp)
+		}
+		if opts.Animate {
+			return animatedSVG(ctx, w, opts)
+		}
+		return svg(ctx, w, opts)
+	case "json":
+		return meshJSON(ctx, w, opts)
+	case "png":
+		return pngImage(ctx, w, opts)
+	case "gif":
+		return animatedGIF(ctx, w, opts)
+	case "pdf":
+		return pdfDocument(ctx, w, opts)
+	case "obj":
+		return objMesh(ctx, w, opts)
+	case "stl":
+		return stlMesh(ctx, w, opts)
+	case "gltf":
+		return gltfMesh(ctx, w, opts)
+	}
+	return fmt.Errorf("surface: unknown Format %q", opts.Format)
+}
 
-	w.Header().Set("Content-Type", "image/svg+xml")
-	svg(w, projector, peakColor, valleyColor)
+// trace starts stage of the render for opts.Trace, returning the function
+// that ends it.
+func (opts Options) trace(stage string) (end func()) {
+	if opts.Trace == nil {
+		return func() {}
+	}
+	return opts.Trace(stage)
+}
+
+// svg writes the SVG document for opts to w. It stops early, returning the
+// context's error, if ctx is cancelled during the render.
+func svg(ctx context.Context, w io.Writer, opts Options) error {
+	m, err := sample(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer opts.trace("encode")()
+	levels, err := contourLevels(m.bounds, opts)
+	if err != nil {
+		return err
+	}
+	svgHeader(w, m.bounds, opts)
+	if err := surface(ctx, w, m, opts, ""); err != nil {
+		return err
+	}
+	if err := contours(ctx, w, m, levels, opts); err != nil {
+		return err
+	}
+	if opts.Legend {
+		legend(w, m.bounds, opts)
+	}
+	fmt.Fprint(w, "</svg>")
+	return nil
 }
 
-func errorf(format string, a ...any) string {
-	return fmt.Sprintf("error: "+format, a)
+// svgHeader writes the opening <svg> tag and the metadata of opts. If
+// opts.Fit is set, the viewBox encloses the projected extents in b.
+func svgHeader(w io.Writer, b bounds, opts Options) {
+	var viewBox string
+	if opts.Fit {
+		viewBox = fmt.Sprintf("viewBox='%s' ", b.viewBox(opts.Width, opts.Height, opts.Padding))
+	}
+	stroke := "grey"
+	if c := opts.Stroke; !opts.outlined() {
+		stroke = "none"
+	} else if c != grey {
+		stroke = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	style := fmt.Sprintf("stroke: %s; fill: white; stroke-width: %g", stroke, opts.StrokeWidth)
+	if opts.StrokeOpacity < 1 {
+		style += fmt.Sprintf("; stroke-opacity: %g", opts.StrokeOpacity)
+	}
+	if opts.FillOpacity < 1 {
+		style += fmt.Sprintf("; fill-opacity: %g", opts.FillOpacity)
+	}
+	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' role='img' "+
+		"style='%s' %swidth='%d' height='%d'>", style, viewBox, opts.Width, opts.Height)
+	svgMetadata(w, opts)
+	if c := opts.Background; c.A > 0 {
+		x, y, vw, vh := 0.0, 0.0, float64(opts.Width), float64(opts.Height)
+		if opts.Fit {
+			x, y, vw, vh = b.viewRect(opts.Width, opts.Height, opts.Padding)
+		}
+		// The fill-opacity of the cells does not apply.
+		fmt.Fprintf(w, "<rect x='%g' y='%g' width='%g' height='%g' fill='#%02x%02x%02x' fill-opacity='%.3g' stroke='none'/>\n",
+			x, y, vw, vh, c.R, c.G, c.B, float64(c.A)/255)
+	}
 }
 
-func svg(w io.Writer, p Projector, peakColor, valleyColor color.RGBA) {
-	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' "+
-		"style='stroke: grey; fill: white; stroke-width: 0.7' "+
-		"width='%d' height='%d'>", width, height)
+// merged reports whether runs of cells of the same fill are drawn as one
+// path, which cells with tooltips or gradients of their own cannot be.
+func (o Options) merged() bool {
+	return o.Merge && !o.Tooltips && !(o.Gradient && !o.Wireframe)
+}
 
-	surface(w, p, peakColor, valleyColor)
-	fmt.Fprint(w, "</svg>")
+// outlined reports whether the cells are drawn with outlines.
+func (o Options) outlined() bool {
+	return o.View != "heatmap" || o.Wireframe
 }
 
-func surface(out io.Writer, p Projector, peakColor, valleyColor color.RGBA) {
-	const polygonf string = "<polygon points='%s' fill='%s'/>\n"
-	var zmax, zmin float64 = math.Inf(-1), math.Inf(1)
-	var polygons [cells][cells][9]float64
-
-	for i := 0; i < cells; i++ {
-		for j := 0; j < cells; j++ {
-			ax, ay, az := p.corner(i+1, j)
-			bx, by, bz := p.corner(i, j)
-			cx, cy, cz := p.corner(i, j+1)
-			dx, dy, dz := p.corner(i+1, j+1)
-			// Skip polygon if value is NaN or Inf.
-			if err := az + bz + cz + dz; math.IsNaN(err) || math.IsInf(err, 0) {
-				continue
-			}
+// surface writes the cells of m as SVG polygons. The ids of any gradients
+// start with prefix, which keeps them unique across frames.
+func surface(ctx context.Context, out io.Writer, m *mesh, opts Options, prefix string) error {
+	bp := cellBuffers.Get().(*[]byte)
+	defer cellBuffers.Put(bp)
+	buf := (*bp)[:0]
+	var mg *merger
+	if opts.merged() {
+		mg = new(merger)
+	}
+	for n, ij := range m.order {
+		if err := m.checkpoint(ctx, n); err != nil {
+			return err
+		}
+		i, j := ij[0], ij[1]
+		if mg != nil {
+			buf = mg.add(buf, m, m.polygons[i][j], opts)
+		} else {
+			buf = m.appendCell(buf, m.polygons[i][j], i, j, opts, prefix)
+		}
+		if len(buf) >= flushSize {
+			out.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	if mg != nil {
+		buf = mg.flush(buf)
+	}
+	out.Write(buf)
+	*bp = buf
+	return nil
+}
 
-			ax, ay = project(ax, ay, az)
-			bx, by = project(bx, by, bz)
-			cx, cy = project(cx, cy, cz)
-			dx, dy = project(dx, dy, dz)
-			z := average(az, bz, cz, dz)
-			polygons[i][j] = [9]float64{z, ax, ay, bx, by, cx, cy, dx, dy}
+// flushSize is the size at which buffered cells are written out.
+const flushSize = 32 << 10
 
-			zmax = max(zmax, az, bz, cz, dz)
-			zmin = min(zmin, az, bz, cz, dz)
+// cellBuffers hold the SVG of cells until it is written out, and are
+// reused across renders so that the cells are written without allocating.
+var cellBuffers = sync.Pool{New: func() any { return new([]byte) }}
 
+// appendCell appends p, the cell (i,j), to buf as an SVG polygon colored by
+// its height within m.
+func (m *mesh) appendCell(buf []byte, p polygon, i, j int, opts Options, prefix string) []byte {
+	var id []byte
+	if opts.Gradient && !opts.Wireframe {
+		id = append([]byte("g"+prefix), strconv.Itoa(i)+"-"+strconv.Itoa(j)...)
+		var ok bool
+		if buf, ok = m.appendGradient(buf, p, id, opts); !ok {
+			id = nil
 		}
 	}
+	buf = append(buf, "<polygon points='"...)
+	buf = appendPoints(buf, p.points[:2*p.sides()], opts.Precision)
+	buf = append(buf, "' fill='"...)
+	if id != nil {
+		buf = append(buf, "url(#"...)
+		buf = append(buf, id...)
+		buf = append(buf, ")'"...)
+	} else {
+		buf = m.appendFill(buf, p, opts)
+	}
+	if opts.Tooltips {
+		// The data attributes carry the values of the cell for scripts, and
+		// the title, a child of the polygon rather than a wrapping group so
+		// tooltips add one element per cell instead of two, shows them.
+		x, y, z := p.center[0], p.center[1], opts.unmapZ(p.z)
+		buf = append(buf, " data-x='"...)
+		buf = strconv.AppendFloat(buf, x, 'g', 6, 64)
+		buf = append(buf, "' data-y='"...)
+		buf = strconv.AppendFloat(buf, y, 'g', 6, 64)
+		buf = append(buf, "' data-z='"...)
+		buf = strconv.AppendFloat(buf, z, 'g', -1, 64)
+		buf = append(buf, "'><title>x="...)
+		buf = strconv.AppendFloat(buf, x, 'g', 6, 64)
+		buf = append(buf, " y="...)
+		buf = strconv.AppendFloat(buf, y, 'g', 6, 64)
+		buf = append(buf, " z="...)
+		buf = strconv.AppendFloat(buf, z, 'g', -1, 64)
+		return append(buf, "</title></polygon>\n"...)
+	}
+	return append(buf, "/>\n"...)
+}
 
-	for i := 0; i < cells; i++ {
-		for j := 0; j < cells; j++ {
-			var points strings.Builder
-			for i, p := range polygons[i][j][1:] {
-				points.WriteString(strconv.FormatFloat(p, 'f', 6, 64))
-				if i != len(polygons[i][j][1:])-1 {
-					points.WriteString(", ")
-				}
-			}
-			z := polygons[i][j][0]
-			c := zcolor(z, zmax, zmin, valleyColor, peakColor)
+// appendFill appends the flat fill of p, closing the fill attribute and
+// adding a fill-opacity attribute if p is translucent.
+func (m *mesh) appendFill(buf []byte, p polygon, opts Options) []byte {
+	if opts.Wireframe {
+		return append(buf, "none'"...)
+	}
+	c := m.color(p, opts)
+	buf = append(appendHex(buf, c), '\'')
+	if c.A < 255 {
+		// Replaces the inherited opacity, so include it.
+		buf = append(buf, " fill-opacity='"...)
+		buf = append(strconv.AppendFloat(buf, opts.fillOpacity(c), 'g', 3, 64), '\'')
+	}
+	return buf
+}
+
+// appendCoord appends v to buf rounded to precision decimal places,
+// without trailing zeros.
+func appendCoord(buf []byte, v float64, precision int) []byte {
+	n := len(buf)
+	buf = strconv.AppendFloat(buf, v, 'f', precision, 64)
+	if precision > 0 {
+		buf = bytes.TrimRight(buf, "0")
+		buf = bytes.TrimSuffix(buf, []byte("."))
+	}
+	if string(buf[n:]) == "-0" {
+		buf = append(buf[:n], '0')
+	}
+	return buf
+}
+
+// appendHex appends c to buf as #rrggbb.
+func appendHex(buf []byte, c color.RGBA) []byte {
+	const digits = "0123456789abcdef"
+	return append(buf, '#',
+		digits[c.R>>4], digits[c.R&15], digits[c.G>>4], digits[c.G&15], digits[c.B>>4], digits[c.B&15])
+}
+
+// appendGradient appends a linearGradient with the given id running from
+// the lowest to the highest corner of p, in user space so that it follows
+// the projected cell. It appends nothing and returns false if the corners
+// are level.
+func (m *mesh) appendGradient(buf []byte, p polygon, id []byte, opts Options) ([]byte, bool) {
+	lo, hi := 0, 0
+	for k, z := range p.corners {
+		if z < p.corners[lo] {
+			lo = k
+		}
+		if z > p.corners[hi] {
+			hi = k
+		}
+	}
+	if p.corners[lo] == p.corners[hi] || opts.ColorBy == "slope" {
+		return buf, false // a cell has one slope
+	}
+	if p.hole {
+		return buf, false
+	}
+	c0 := shade(opts.layerRamp(p.layer, p.corners[lo], m.zmin, m.zmax), p.shade)
+	c1 := shade(opts.layerRamp(p.layer, p.corners[hi], m.zmin, m.zmax), p.shade)
+	f := func(v float64) string { return string(appendCoord(nil, v, opts.Precision)) }
+	buf = fmt.Appendf(buf, "<linearGradient id='%s' gradientUnits='userSpaceOnUse' x1='%s' y1='%s' x2='%s' y2='%s'>"+
+		"<stop stop-color='#%02x%02x%02x'%s/><stop offset='1' stop-color='#%02x%02x%02x'%s/></linearGradient>",
+		id, f(p.points[2*lo]), f(p.points[2*lo+1]), f(p.points[2*hi]), f(p.points[2*hi+1]),
+		c0.R, c0.G, c0.B, stopOpacity(c0), c1.R, c1.G, c1.B, stopOpacity(c1))
+	return buf, true
+}
+
+// formatPoints formats pts as the points attribute of an SVG polygon.
+func formatPoints(pts []float64, precision int) string {
+	return string(appendPoints(nil, pts, precision))
+}
+
+// appendPoints appends pts to buf as formatted by formatPoints.
+func appendPoints(buf []byte, pts []float64, precision int) []byte {
+	for k, p := range pts {
+		buf = appendCoord(buf, p, precision)
+		if k != len(pts)-1 {
+			buf = append(buf, ", "...)
+		}
+	}
+	return buf
+}
 
-			fmt.Fprintf(out, polygonf, points.String(), fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+// color returns the fill of p: its height on the gradient of opts, or the
+// HoleColor of a filled hole, shaded.
+func (m *mesh) color(p polygon, opts Options) color.RGBA {
+	if p.hole {
+		c := opts.HoleColor
+		if c == (color.RGBA{}) {
+			c = grey
 		}
+		return shade(c, p.shade)
 	}
+	return shade(opts.layerRamp(p.layer, p.value, m.vmin, m.vmax), p.shade)
 }
 
-func zcolor(z, zmax, zmin float64, high, low color.RGBA) color.RGBA {
-	percent := percent(zmin, zmax, z)
+// fillOpacity returns the opacity of a cell filled with c.
+func (o Options) fillOpacity(c color.RGBA) float64 {
+	return o.FillOpacity * float64(c.A) / 255
+}
+
+// stopOpacity returns the stop-opacity attribute of a gradient stop of
+// color c, which the fill-opacity of the cell multiplies.
+func stopOpacity(c color.RGBA) string {
+	if c.A == 255 {
+		return ""
+	}
+	return fmt.Sprintf(" stop-opacity='%.3g'", float64(c.A)/255)
+}
+
+// ramp returns the color of height z in a surface whose heights range
+// from zmin to zmax.
+func (o Options) ramp(z, zmin, zmax float64) color.RGBA {
+	return o.rampOf(z, zmin, zmax, o.Stops, o.Offsets)
+}
+
+// rampOf is ramp but for the gradient through stops at offsets.
+func (o Options) rampOf(z, zmin, zmax float64, stops []color.RGBA, offsets []float64) color.RGBA {
+	if o.Diverging {
+		half := max(zmax-o.Center, o.Center-zmin)
+		z, zmin, zmax = z-o.Center, -half, half
+	}
+	if o.Bands > 0 {
+		t := min(max(percent(zmin, zmax, z), 0), 1)
+		k := min(int(t*float64(o.Bands)), o.Bands-1)
+		z, zmin, zmax = float64(k), 0, float64(max(o.Bands-1, 1))
+	}
+	return zcolor(z, zmax, zmin, stops, offsets)
+}
+
+// zcolor returns the color of height z on the gradient through stops, which
+// lie at offsets from zmin to zmax, or are evenly spaced if offsets is nil.
+func zcolor(z, zmax, zmin float64, stops []color.RGBA, offsets []float64) color.RGBA {
+	if len(stops) == 1 {
+		return stops[0]
+	}
+	percent := min(max(percent(zmin, zmax, z), 0), 1)
+	var k int
+	var x float64
+	if offsets == nil {
+		segment := percent * float64(len(stops)-1)
+		k = min(int(segment), len(stops)-2)
+		x = segment - float64(k)
+	} else {
+		k = max(sort.SearchFloat64s(offsets, percent)-1, 0)
+		k = min(k, len(stops)-2)
+		x = min(max(percent-offsets[k], 0)/max(offsets[k+1]-offsets[k], 1e-12), 1)
+	}
+	low, high := stops[k], stops[k+1]
 	currentColor := color.RGBA{
-		R: interpolate(high.R, low.R, percent),
-		G: interpolate(high.G, low.G, percent),
-		B: interpolate(high.B, low.B, percent),
-		A: high.A,
+		R: interpolate(low.R, high.R, x),
+		G: interpolate(low.G, high.G, x),
+		B: interpolate(low.B, high.B, x),
+		A: uint8(float64(low.A)*(1-x) + float64(high.A)*x + 0.5),
 	}
 	return currentColor
 }
 
+// percent reports where c lies in [cmin, cmax] as a fraction. A collapsed
+// (or empty) range maps every value to 1, i.e. the peak color.
 func percent(cmin, cmax, c float64) float64 {
+	if cmax <= cmin {
+		return 1
+	}
 	return (c - cmin) / (cmax - cmin)
 }
 
@@ -169,19 +992,3 @@ func average(a ...float64) float64 {
 	}
 	return sum / float64(len(a))
 }
-
-func hexToRGBA(hex string) (color.RGBA, error) {
-	values, err := strconv.ParseUint(string(hex), 16, 32)
-
-	if err != nil {
-		return color.RGBA{}, err
-	}
-
-	rgb := color.RGBA{
-		R: uint8(values >> 16),
-		G: uint8((values >> 8) & 0xFF),
-		B: uint8(values & 0xFF),
-	}
-
-	return rgb, nil
-}
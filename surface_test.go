@@ -0,0 +1,301 @@
+package surface
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"image/color"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plane is a Projector of the constant height z.
+type plane struct{ z float64 }
+
+func (p plane) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	return x, y, p.z
+}
+
+// spike is a Projector of a flat plane with a single tall spike at the
+// middle of the grid.
+type spike struct{}
+
+func (spike) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	nx, ny := g.Cells()
+	if i == nx/2 && j == ny/2 {
+		return x, y, 1e6
+	}
+	return x, y, 0
+}
+
+// render returns the SVG of opts, failing the test if Render fails.
+func render(t *testing.T, opts Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Render(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var (
+	fillRe    = regexp.MustCompile(`fill='([^']*)'`)
+	pointsRe  = regexp.MustCompile(`points='([^']*)'`)
+	viewBoxRe = regexp.MustCompile(`viewBox='([^']*)'`)
+)
+
+// numbers returns the numbers of the list s, separated by commas or spaces.
+func numbers(t *testing.T, s string) []float64 {
+	t.Helper()
+	var fs []float64
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		fs = append(fs, v)
+	}
+	return fs
+}
+
+// points returns the corners of the polygons of svg, as x, y pairs.
+func points(t *testing.T, svg []byte) [][2]float64 {
+	t.Helper()
+	var ps [][2]float64
+	for _, m := range pointsRe.FindAllSubmatch(svg, -1) {
+		fs := numbers(t, string(m[1]))
+		for k := 0; k+1 < len(fs); k += 2 {
+			ps = append(ps, [2]float64{fs[k], fs[k+1]})
+		}
+	}
+	return ps
+}
+
+func TestFitViewBox(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Projector, _ = LookupProjector("saddle")
+	opts.Cells = 20
+	opts.Fit = true
+	svg := render(t, opts)
+	m := viewBoxRe.FindSubmatch(svg)
+	if m == nil {
+		t.Fatal("no viewBox")
+	}
+	vb := numbers(t, string(m[1]))
+	ps := points(t, svg)
+	if len(ps) == 0 {
+		t.Fatal("no polygons")
+	}
+	for _, p := range ps {
+		if p[0] < vb[0] || p[0] > vb[0]+vb[2] || p[1] < vb[1] || p[1] > vb[1]+vb[3] {
+			t.Fatalf("point %v is outside the viewBox %v", p, vb)
+		}
+	}
+}
+
+func TestRenderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := DefaultOptions()
+	opts.Cells = 1000
+	var buf bytes.Buffer
+	start := time.Now()
+	err := RenderContext(ctx, &buf, opts)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled render took %v", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("</svg>")) {
+		t.Error("cancelled render wrote a whole SVG")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	svgs := make(map[string][]byte)
+	for _, deg := range []string{"0", "90", "360"} {
+		opts, _, err := ParseQuery(mustQuery(t, "function=saddle&cells=20&rotate="+deg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Metadata = nil // which records the query
+		svgs[deg] = render(t, opts)
+	}
+	if !bytes.Equal(svgs["0"], svgs["360"]) {
+		t.Error("rotate=360 differs from rotate=0")
+	}
+	if bytes.Equal(svgs["0"], svgs["90"]) {
+		t.Error("rotate=90 equals rotate=0")
+	}
+}
+
+func TestThreeStops(t *testing.T) {
+	stops := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	for _, c := range []struct {
+		z    float64
+		want color.RGBA
+	}{
+		{0, stops[0]},
+		{25, color.RGBA{R: 127, G: 127, A: 255}}, // halfway from red to green
+		{50, stops[1]},
+		{75, color.RGBA{G: 127, B: 127, A: 255}}, // halfway from green to blue
+		{100, stops[2]},
+	} {
+		if got := zcolor(c.z, 100, 0, stops, nil); got != c.want {
+			t.Errorf("z=%g: %v, want %v", c.z, got, c.want)
+		}
+	}
+}
+
+func TestWireframe(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Projector, _ = LookupProjector("saddle")
+	opts.Cells = 10
+	opts.Wireframe = true
+	svg := render(t, opts)
+	if n := svgElements(t, svg)["polygon"]; n != 100 {
+		t.Errorf("%d polygons, want 100", n)
+	}
+	if bytes.Contains(svg, []byte("fill='#")) {
+		t.Error("wireframe has filled cells")
+	}
+}
+
+func TestZClamp(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Projector = spike{}
+	opts.Cells = 10
+	opts.ZClamp = 1
+	for _, p := range points(t, render(t, opts)) {
+		if p[1] < 0 || p[1] > float64(opts.Height) {
+			t.Fatalf("point %v is off the canvas of height %d", p, opts.Height)
+		}
+	}
+}
+
+func TestRangeCorners(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		half  float64
+	}{{"cells=10", 15}, {"cells=10&range=10", 5}} {
+		opts, _, err := ParseQuery(mustQuery(t, c.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := opts.grid()
+		x0, y0 := g.Corner(0, 0)
+		x1, y1 := g.Corner(g.Cells())
+		if x0 != -c.half || y0 != -c.half || x1 != c.half || y1 != c.half {
+			t.Errorf("%s: corners (%g,%g) and (%g,%g), want ±%g", c.query, x0, y0, x1, y1, c.half)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Projector, _ = LookupProjector("saddle")
+	opts.Cells = 10
+	for _, precision := range []int{0, 2, 4} {
+		opts.Precision = precision
+		most := 0
+		for _, m := range pointsRe.FindAllSubmatch(render(t, opts), -1) {
+			for _, f := range strings.FieldsFunc(string(m[1]), func(r rune) bool { return r == ',' || r == ' ' }) {
+				if _, frac, ok := strings.Cut(f, "."); ok {
+					most = max(most, len(frac))
+				}
+			}
+		}
+		if most != precision {
+			t.Errorf("precision %d: up to %d decimal places", precision, most)
+		}
+	}
+}
+
+func TestConstantHeightFills(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Projector = plane{z: 0.5}
+	opts.Cells = 10
+	opts.Stops = []color.RGBA{{R: 255, A: 255}, {B: 255, A: 255}}
+	fills := fillRe.FindAllSubmatch(render(t, opts), -1)
+	if len(fills) != 100 {
+		t.Fatalf("%d fills, want 100", len(fills))
+	}
+	for _, f := range fills {
+		// A collapsed range takes the peak color.
+		if got := string(f[1]); got != "#0000ff" {
+			t.Fatalf("fill %q, want the peak color #0000ff", got)
+		}
+	}
+}
+
+// svgElements parses the SVG in data and returns the number of each
+// element in it, failing if it is not an SVG document.
+func svgElements(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+	d := xml.NewDecoder(bytes.NewReader(data))
+	n := make(map[string]int)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid SVG: %v", err)
+		}
+		if e, ok := tok.(xml.StartElement); ok {
+			if len(n) == 0 && e.Name.Local != "svg" {
+				t.Fatalf("root element is %s, want svg", e.Name.Local)
+			}
+			n[e.Name.Local]++
+		}
+	}
+	return n
+}
+
+func TestRender(t *testing.T) {
+	saddle, _ := LookupProjector("saddle")
+	opts := DefaultOptions()
+	opts.Projector = saddle
+	opts.Cells = 10
+	var buf bytes.Buffer
+	if err := Render(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := svgElements(t, buf.Bytes())["polygon"]; n != 100 {
+		t.Errorf("%d polygons, want 100", n)
+	}
+}
+
+// BenchmarkSurface measures writing the SVG of the cells of a sampled mesh.
+func BenchmarkSurface(b *testing.B) {
+	opts := DefaultOptions().withDefaults()
+	m, err := sample(context.Background(), opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for range b.N {
+		if err := surface(context.Background(), io.Discard, m, opts, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRender measures the render of a request without parameters.
+func BenchmarkRender(b *testing.B) {
+	opts := DefaultOptions()
+	b.ReportAllocs()
+	for range b.N {
+		if err := Render(io.Discard, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package surface
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	defaultOctaves   = 6
+	defaultRoughness = 0.5
+	maxOctaves       = 16
+	terrainScale     = 8   // x and y units per feature of the first octave
+	terrainHeight    = 0.3 // z of the highest peaks, about
+)
+
+// TerrainProjector is a landscape of fractal Perlin noise, reproducible
+// from its seed.
+type TerrainProjector struct {
+	seed      int64
+	octaves   int
+	roughness float64
+	perm      *[512]uint8             // shuffled lattice hashes, twice over
+	offsets   *[maxOctaves][2]float64 // of the noise of each octave
+}
+
+// NewTerrainProjector returns the terrain of seed that sums octaves layers
+// of noise, each of twice the frequency of the last and roughness times its
+// amplitude. octaves is clamped to 1..16 and roughness to (0, 1].
+func NewTerrainProjector(seed int64, octaves int, roughness float64) TerrainProjector {
+	t := TerrainProjector{
+		seed:      seed,
+		octaves:   max(1, min(octaves, maxOctaves)),
+		roughness: roughness,
+		perm:      new([512]uint8),
+		offsets:   new([maxOctaves][2]float64),
+	}
+	if !(t.roughness > 0 && t.roughness <= 1) {
+		t.roughness = defaultRoughness
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	for k, v := range rnd.Perm(256) {
+		t.perm[k], t.perm[k+256] = uint8(v), uint8(v)
+	}
+	// Offsetting the octaves keeps the lattice points, where noise is 0, of
+	// one from lining up with those of the others.
+	for k := range t.offsets {
+		t.offsets[k] = [2]float64{256 * rnd.Float64(), 256 * rnd.Float64()}
+	}
+	return t
+}
+
+func (TerrainProjector) Description() string {
+	return "a landscape of fractal noise, set by seed, octaves and roughness"
+}
+
+func (t TerrainProjector) Corner(g Grid, i, j int) (float64, float64, float64) {
+	x, y := g.Corner(i, j)
+	var z, total float64
+	freq, amp := 1.0/terrainScale, 1.0
+	for k := 0; k < t.octaves; k++ {
+		z += amp * t.noise(x*freq+t.offsets[k][0], y*freq+t.offsets[k][1])
+		total += amp
+		freq, amp = 2*freq, amp*t.roughness
+	}
+	return x, y, terrainHeight * z / total
+}
+
+// noise is Perlin's gradient noise at (x,y), in about [-1, 1].
+func (t TerrainProjector) noise(x, y float64) float64 {
+	fx, fy := math.Floor(x), math.Floor(y)
+	xi, yi := int(fx)&255, int(fy)&255
+	x, y = x-fx, y-fy
+	u, v := fade(x), fade(y)
+	p := t.perm
+	a, b := int(p[xi])+yi, int(p[xi+1])+yi
+	return lerp(v,
+		lerp(u, gradient(p[a], x, y), gradient(p[b], x-1, y)),
+		lerp(u, gradient(p[a+1], x, y-1), gradient(p[b+1], x-1, y-1)))
+}
+
+// fade eases t in [0, 1] so that noise is smooth across lattice cells.
+func fade(t float64) float64 { return t * t * t * (t*(t*6-15) + 10) }
+
+func lerp(t, a, b float64) float64 { return a + t*(b-a) }
+
+// gradient is the dot product of (x,y) with one of eight lattice
+// gradients picked by hash h.
+func gradient(h uint8, x, y float64) float64 {
+	switch h & 7 {
+	case 0:
+		return x + y
+	case 1:
+		return x - y
+	case 2:
+		return -x + y
+	case 3:
+		return -x - y
+	case 4:
+		return x
+	case 5:
+		return -x
+	case 6:
+		return y
+	}
+	return -y
+}
@@ -0,0 +1,332 @@
+package surface
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the key of a WebSocket handshake to accept
+// it, as RFC 6455 specifies.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsText   = 1
+	wsBinary = 2
+	wsClose  = 8
+	wsPing   = 9
+	wsPong   = 10
+)
+
+// refinements are the divisors of cells of the coarser renderings that
+// precede the one asked for on /ws.
+var refinements = []int{8, 4, 2}
+
+// minPreviewCells is the fewest cells per side of a coarser rendering.
+const minPreviewCells = 10
+
+// serveWebSocket answers a WebSocket handshake on /ws, with the query
+// parameters of a render, by sending renderings of increasing resolution,
+// each in a message of its own and ending with the one asked for, then
+// closing the connection. Renderings are text messages in the SVG, JSON
+// and OBJ formats and binary ones in the others.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	cfg := h.config.Load()
+	var rep Report
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	if cfg.Report != nil {
+		defer func() {
+			rep.Duration, rep.Status = time.Since(start), rec.status
+			cfg.Report(r, rep)
+		}()
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		WriteError(w, errors.New("method "+r.Method+" not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		WriteError(w, errors.New("/ws needs a WebSocket handshake"), http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		WriteError(w, errors.New("unsupported WebSocket version, want 13"), http.StatusBadRequest)
+		return
+	}
+	// Browsers let any page open a WebSocket, with the cookies of the site.
+	if !allowedOrigin(r, cfg.Origins) {
+		WriteError(w, fmt.Errorf("origin %q may not open a WebSocket", r.Header.Get("Origin")), http.StatusForbidden)
+		return
+	}
+	r, opts, lim, ok := h.prepare(w, r, cfg, &rep)
+	if !ok {
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	// Renders stop when the client closes the connection or they run out
+	// of time.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if lim.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), lim.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	defer cancel()
+	ws := &wsConn{conn: conn, bw: brw.Writer, read: make(chan struct{})}
+	go ws.readLoop(brw.Reader, cancel)
+	defer ws.wait()
+
+	opcode := byte(wsBinary)
+	switch opts.Format {
+	case "", "svg", "json", "obj":
+		opcode = wsText
+	}
+	divisors := []int{}
+	for _, d := range refinements {
+		if min(opts.Cells, opts.cellsY())/d >= minPreviewCells {
+			divisors = append(divisors, d)
+		}
+	}
+	divisors = append(divisors, 1)
+	for _, d := range divisors {
+		o := opts
+		o.Cells, o.CellsY = opts.Cells/d, opts.CellsY/d
+		var buf bytes.Buffer
+		out := &limitWriter{w: &buf, n: lim.MaxBytes, stop: cancel}
+		if out.n <= 0 {
+			out.n = math.MaxInt64
+		}
+		if !h.acquire() {
+			ws.close(1013, "too many renders in progress")
+			return
+		}
+		err := RenderContext(ctx, out, o)
+		h.release()
+		switch {
+		case out.over:
+			ws.close(1009, "response is larger than the limit")
+			return
+		case ws.closed() || r.Context().Err() != nil:
+			rep.Aborted = true
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			ws.close(1011, "render took longer than the limit")
+			return
+		case err != nil:
+			ws.close(1011, err.Error())
+			return
+		}
+		if err := ws.write(opcode, buf.Bytes()); err != nil {
+			rep.Aborted = true
+			return
+		}
+		rep.Bytes += buf.Len()
+	}
+	ws.close(1000, "")
+}
+
+// allowedOrigin reports whether the WebSocket handshake r may come from the
+// page of its Origin: one of origins, any for "*", or one of the host of r.
+// Clients other than browsers send no Origin.
+func allowedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerHas reports whether the comma-separated header name of h lists
+// token, ignoring case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	mu   sync.Mutex // guards bw and done
+	bw   *bufio.Writer
+	done bool          // a close frame was sent or received
+	read chan struct{} // closed once readLoop returns
+}
+
+// write sends the message p in a single frame.
+func (c *wsConn) write(opcode byte, p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return net.ErrClosed
+	}
+	return c.writeFrame(opcode, p)
+}
+
+// writeFrame writes an unmasked, final frame. c.mu must be held.
+func (c *wsConn) writeFrame(opcode byte, p []byte) error {
+	hdr := []byte{0x80 | opcode}
+	switch n := len(p); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	c.bw.Write(hdr)
+	c.bw.Write(p)
+	return c.bw.Flush()
+}
+
+// close sends a close frame with code and reason, unless one was sent or
+// received already.
+func (c *wsConn) close(code uint16, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return
+	}
+	c.done = true
+	if len(reason) > 123 {
+		reason = reason[:123] // control frames carry at most 125 bytes
+	}
+	c.writeFrame(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+}
+
+func (c *wsConn) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// closeWait is how long to wait for the client to answer a close frame.
+const closeWait = 5 * time.Second
+
+// wait waits for the client to close the connection, for up to closeWait,
+// so that closing it does not discard messages it has yet to read.
+func (c *wsConn) wait() {
+	select {
+	case <-c.read:
+	case <-time.After(closeWait):
+	}
+}
+
+// readLoop reads the frames of the client, answering pings and discarding
+// data, until it closes the connection or fails, and then calls cancel.
+func (c *wsConn) readLoop(r *bufio.Reader, cancel context.CancelFunc) {
+	defer close(c.read)
+	defer cancel()
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			c.mu.Lock()
+			c.done = true
+			c.mu.Unlock()
+			return
+		}
+		switch opcode {
+		case wsPing:
+			c.mu.Lock()
+			if !c.done {
+				c.writeFrame(wsPong, payload)
+			}
+			c.mu.Unlock()
+		case wsClose:
+			c.mu.Lock()
+			if !c.done {
+				c.done = true
+				c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			}
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// maxClientFrame is the largest frame a client may send.
+const maxClientFrame = 1 << 16
+
+// readFrame reads a masked frame of the client.
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > maxClientFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for k := range payload {
+		payload[k] ^= mask[k%4]
+	}
+	return hdr[0] & 0x0f, payload, nil
+}
@@ -0,0 +1,149 @@
+package surface
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWebSocket sends a WebSocket handshake for target to srv, from a page
+// of origin if not empty, and returns the connection and the response.
+func dialWebSocket(t *testing.T, srv *httptest.Server, target, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+target, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+// readServerFrame reads an unmasked frame of the server.
+func readServerFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return hdr[0]&0x80 != 0, hdr[0] & 0x0f, payload, err
+}
+
+// writeClientFrame writes a final frame masked, as clients must.
+func writeClientFrame(w io.Writer, opcode byte, payload []byte) error {
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask[:]...)
+	for k, b := range payload {
+		frame = append(frame, b^mask[k%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func TestWebSocket(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(HandlerConfig{}))
+	defer srv.Close()
+	conn, br, resp := dialWebSocket(t, srv, "/ws?cells=10", "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+	// The accept key of the sample handshake of RFC 6455.
+	sum := sha1.Sum([]byte("dGhlIHNhbXBsZSBub25jZQ==" + websocketGUID))
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), base64.StdEncoding.EncodeToString(sum[:]); got != want || want != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept %q, want %q", got, want)
+	}
+	// Too few cells for previews: the rendering, then the close frame.
+	fin, opcode, payload, err := readServerFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fin || opcode != wsText || !strings.HasPrefix(string(payload), "<svg") || !strings.HasSuffix(string(payload), "</svg>") {
+		t.Errorf("message: fin %v, opcode %d, %.20q; want a final text frame of an SVG", fin, opcode, payload)
+	}
+	_, opcode, payload, err = readServerFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Fatalf("close: opcode %d, payload %v; want a close frame with code 1000", opcode, payload)
+	}
+	// The server reads the masked close frame of the client and hangs up.
+	if err := writeClientFrame(conn, wsClose, payload[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("after the closing handshake: %v, want EOF", err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	var b strings.Builder
+	writeClientFrame(&b, wsPing, []byte("hello"))
+	opcode, payload, err := readFrame(bufio.NewReader(strings.NewReader(b.String())))
+	if err != nil || opcode != wsPing || string(payload) != "hello" {
+		t.Errorf("readFrame: %d %q %v, want a ping of hello", opcode, payload, err)
+	}
+	// Clients must mask their frames.
+	unmasked := string([]byte{0x80 | wsText, 2}) + "hi"
+	if _, _, err := readFrame(bufio.NewReader(strings.NewReader(unmasked))); err == nil {
+		t.Error("readFrame accepted an unmasked frame")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(HandlerConfig{Origins: []string{"https://plots.example"}}))
+	defer srv.Close()
+	for origin, want := range map[string]int{
+		"":                                       http.StatusSwitchingProtocols, // not a browser
+		"https://plots.example":                  http.StatusSwitchingProtocols,
+		"http://" + srv.Listener.Addr().String(): http.StatusSwitchingProtocols, // the server's own pages
+		"https://evil.example":                   http.StatusForbidden,
+		"https://plots.example.evil":             http.StatusForbidden,
+		"null":                                   http.StatusForbidden,
+	} {
+		_, _, resp := dialWebSocket(t, srv, "/ws?cells=10", origin)
+		if resp.StatusCode != want {
+			t.Errorf("Origin %q: status %d, want %d", origin, resp.StatusCode, want)
+		}
+	}
+}